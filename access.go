@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// checkAccess is set by --check-access, which makes a dry run also confirm
+// every candidate could really be copied
+var checkAccess bool
+
+// checkFileAccess opens the source of a file a dry run would copy and probes
+// the target directory for write access, logging and counting any problem
+// without transferring any data.
+func (j *jobRun) checkFileAccess(sourcePath, targetPath string) {
+	file, err := os.Open(toLongPath(sourcePath))
+	if err != nil {
+		j.logError(fmt.Sprintf("Access problem, cannot read source: %v", err))
+		j.countError()
+		return
+	}
+	file.Close()
+
+	if !config.ForceOverwriteReadonly && isReadOnly(toLongPath(targetPath)) {
+		j.logError(fmt.Sprintf("Access problem, target is read-only: %s", targetPath))
+		j.countError()
+		return
+	}
+
+	dir := existingAncestor(filepath.Dir(targetPath))
+	result, probed := j.writableDirs.Load(dir)
+	if !probed {
+		result, _ = j.writableDirs.LoadOrStore(dir, probeWrite(dir))
+	}
+	if err, _ := result.(error); err != nil {
+		j.logError(fmt.Sprintf("Access problem, cannot write to target %s: %v", targetPath, err))
+		j.countError()
+	}
+}
+
+// probeWrite creates and removes an empty file in dir to find out whether it
+// can be written. It returns nil when it can.
+func probeWrite(dir string) any {
+	file, err := os.CreateTemp(toLongPath(dir), ".gosync-access-*"+tempFileSuffix)
+	if err != nil {
+		return err
+	}
+	file.Close()
+	os.Remove(file.Name())
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build unix
+
+package main
+
+// copyACL is a no-op: copy_acl only applies to NTFS permissions.
+func copyACL(sourcePath, targetPath string) error {
+	return nil
+}
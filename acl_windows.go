@@ -0,0 +1,28 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// copyACL applies the DACL of sourcePath to targetPath, keeping the source's
+// choice of whether it inherits permissions from the parent folder.
+func copyACL(sourcePath, targetPath string) error {
+	sd, err := windows.GetNamedSecurityInfo(sourcePath, windows.SE_FILE_OBJECT, windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		return err
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	control, _, err := sd.Control()
+	if err != nil {
+		return err
+	}
+
+	info := windows.SECURITY_INFORMATION(windows.DACL_SECURITY_INFORMATION)
+	if control&windows.SE_DACL_PROTECTED != 0 {
+		info |= windows.PROTECTED_DACL_SECURITY_INFORMATION
+	} else {
+		info |= windows.UNPROTECTED_DACL_SECURITY_INFORMATION
+	}
+	return windows.SetNamedSecurityInfo(targetPath, windows.SE_FILE_OBJECT, info, nil, nil, dacl, nil)
+}
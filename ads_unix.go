@@ -0,0 +1,8 @@
+//go:build unix
+
+package main
+
+// copyStreams is a no-op: alternate data streams only exist on NTFS.
+func copyStreams(sourcePath, targetPath string) (int, error) {
+	return 0, nil
+}
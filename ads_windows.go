@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procFindFirstStreamW = windows.NewLazySystemDLL("kernel32.dll").NewProc("FindFirstStreamW")
+	procFindNextStreamW  = windows.NewLazySystemDLL("kernel32.dll").NewProc("FindNextStreamW")
+)
+
+// findStreamData mirrors WIN32_FIND_STREAM_DATA
+type findStreamData struct {
+	StreamSize int64
+	StreamName [windows.MAX_PATH + 36]uint16
+}
+
+// alternateStreams lists the names of the alternate data streams on path,
+// such as "Zone.Identifier", leaving out the unnamed main stream.
+func alternateStreams(path string) ([]string, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var data findStreamData
+	handle, _, err := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(pathPtr)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if windows.Handle(handle) == windows.InvalidHandle {
+		if err == windows.ERROR_HANDLE_EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer windows.FindClose(windows.Handle(handle))
+
+	var names []string
+	for {
+		// Names come back as ":name:$DATA", with "::$DATA" for the main stream
+		name := strings.TrimSuffix(strings.TrimPrefix(windows.UTF16ToString(data.StreamName[:]), ":"), ":$DATA")
+		if name != "" {
+			names = append(names, name)
+		}
+
+		ok, _, err := procFindNextStreamW.Call(handle, uintptr(unsafe.Pointer(&data)))
+		if ok == 0 {
+			if err == windows.ERROR_HANDLE_EOF {
+				return names, nil
+			}
+			return names, err
+		}
+	}
+}
+
+// copyStreams copies every alternate data stream of sourcePath onto
+// targetPath and returns how many were copied.
+func copyStreams(sourcePath, targetPath string) (int, error) {
+	names, err := alternateStreams(sourcePath)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, name := range names {
+		err = copyStream(sourcePath+":"+name, targetPath+":"+name)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(names), nil
+}
+
+func copyStream(sourcePath, targetPath string) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	target, err := os.Create(targetPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(target, source)
+	if err != nil {
+		target.Close()
+		return err
+	}
+	return target.Close()
+}
@@ -0,0 +1,14 @@
+//go:build unix && !linux && !darwin
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// accessTime falls back to the modification time where the Stat_t layout is
+// not known.
+func accessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,48 @@
+//go:build unix
+
+package main
+
+import "os"
+
+// clearFileAttributes is a no-op: Unix has no read-only, hidden or system bits
+// that stop a file being replaced.
+func clearFileAttributes(targetPath string) error {
+	return nil
+}
+
+// isReadOnly always reports false: a read-only mode does not stop a Unix file
+// being replaced by a rename.
+func isReadOnly(path string) bool {
+	return false
+}
+
+// setFileAttributes is a no-op on Unix, where permissions are left to the umask.
+func setFileAttributes(targetPath string, sourceInfo os.FileInfo) error {
+	return nil
+}
+
+// setCompression is a no-op: Unix file systems have no per-file compression
+// attribute to carry over.
+func setCompression(targetFile *os.File, sourceInfo os.FileInfo) error {
+	return nil
+}
+
+// hasArchiveBit always reports false: Unix has no archive bit.
+func hasArchiveBit(sourceInfo os.FileInfo) bool {
+	return false
+}
+
+// clearArchiveBit is a no-op on Unix.
+func clearArchiveBit(path string) error {
+	return nil
+}
+
+// isDehydrated always reports false: Unix has no cloud placeholder attributes.
+func isDehydrated(info os.FileInfo) bool {
+	return false
+}
+
+// excludedAttribute always returns "": Unix files carry no attribute bits.
+func excludedAttribute(info os.FileInfo) string {
+	return ""
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// preservedAttributes are the file attribute bits carried over to the target
+const preservedAttributes = windows.FILE_ATTRIBUTE_READONLY | windows.FILE_ATTRIBUTE_HIDDEN | windows.FILE_ATTRIBUTE_SYSTEM
+
+// fileAttributes returns the Windows attribute bits of info, or 0 when it
+// carries none, as for a FileInfo that did not come from the file system.
+func fileAttributes(info os.FileInfo) uint32 {
+	stat, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return 0
+	}
+	return stat.FileAttributes
+}
+
+// attributeBits maps the names accepted by exclude_attributes to their bits
+var attributeBits = map[string]uint32{
+	attributeHidden:    windows.FILE_ATTRIBUTE_HIDDEN,
+	attributeSystem:    windows.FILE_ATTRIBUTE_SYSTEM,
+	attributeTemporary: windows.FILE_ATTRIBUTE_TEMPORARY,
+	attributeReadOnly:  windows.FILE_ATTRIBUTE_READONLY,
+}
+
+// excludedAttribute returns the first of exclude_attributes set on info, or
+// "" when it has none of them.
+func excludedAttribute(info os.FileInfo) string {
+	attrs := fileAttributes(info)
+	for _, name := range config.ExcludeAttributes {
+		if attrs&attributeBits[name] != 0 {
+			return name
+		}
+	}
+	return ""
+}
+
+// dehydratedAttributes mark a cloud placeholder, such as a OneDrive file kept
+// online only, whose data is downloaded when it is opened
+const dehydratedAttributes = windows.FILE_ATTRIBUTE_RECALL_ON_DATA_ACCESS | windows.FILE_ATTRIBUTE_RECALL_ON_OPEN | windows.FILE_ATTRIBUTE_OFFLINE
+
+// isDehydrated reports whether the source is a cloud placeholder whose data
+// is not on the disk.
+func isDehydrated(info os.FileInfo) bool {
+	return fileAttributes(info)&dehydratedAttributes != 0
+}
+
+// clearFileAttributes removes the preserved attribute bits from an existing
+// target so it can be overwritten. A missing target is not an error.
+func clearFileAttributes(targetPath string) error {
+	pathPtr, err := windows.UTF16PtrFromString(targetPath)
+	if err != nil {
+		return err
+	}
+
+	attrs, err := windows.GetFileAttributes(pathPtr)
+	if err != nil {
+		if err == windows.ERROR_FILE_NOT_FOUND || err == windows.ERROR_PATH_NOT_FOUND {
+			return nil
+		}
+		return err
+	}
+	if attrs&preservedAttributes == 0 {
+		return nil
+	}
+
+	return windows.SetFileAttributes(pathPtr, attrs&^preservedAttributes)
+}
+
+// isReadOnly reports whether the file at path exists and has the read-only bit set.
+func isReadOnly(path string) bool {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return false
+	}
+	attrs, err := windows.GetFileAttributes(pathPtr)
+	return err == nil && attrs&windows.FILE_ATTRIBUTE_READONLY != 0
+}
+
+// setFileAttributes applies the source's read-only, hidden and system bits to the target.
+func setFileAttributes(targetPath string, sourceInfo os.FileInfo) error {
+	wanted := fileAttributes(sourceInfo) & preservedAttributes
+	if wanted == 0 {
+		return nil
+	}
+
+	pathPtr, err := windows.UTF16PtrFromString(targetPath)
+	if err != nil {
+		return err
+	}
+
+	attrs, err := windows.GetFileAttributes(pathPtr)
+	if err != nil {
+		return err
+	}
+
+	return windows.SetFileAttributes(pathPtr, attrs|wanted)
+}
+
+// compressionFormatDefault is COMPRESSION_FORMAT_DEFAULT, the LZNT1 compression
+// Explorer applies
+const compressionFormatDefault uint16 = 1
+
+// setCompression turns on NTFS compression for the open target file when the
+// source has FILE_ATTRIBUTE_COMPRESSED. Doing it before any data is written
+// compresses the copy as it is made instead of rewriting it afterwards.
+func setCompression(targetFile *os.File, sourceInfo os.FileInfo) error {
+	if fileAttributes(sourceInfo)&windows.FILE_ATTRIBUTE_COMPRESSED == 0 {
+		return nil
+	}
+
+	format := compressionFormatDefault
+	var returned uint32
+	return windows.DeviceIoControl(windows.Handle(targetFile.Fd()), windows.FSCTL_SET_COMPRESSION,
+		(*byte)(unsafe.Pointer(&format)), uint32(unsafe.Sizeof(format)), nil, 0, &returned, nil)
+}
+
+// hasArchiveBit reports whether the source has FILE_ATTRIBUTE_ARCHIVE, which
+// Windows sets whenever a file is written.
+func hasArchiveBit(sourceInfo os.FileInfo) bool {
+	return fileAttributes(sourceInfo)&windows.FILE_ATTRIBUTE_ARCHIVE != 0
+}
+
+// clearArchiveBit clears FILE_ATTRIBUTE_ARCHIVE on path once it is backed up.
+func clearArchiveBit(path string) error {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	attrs, err := windows.GetFileAttributes(pathPtr)
+	if err != nil {
+		return err
+	}
+	if attrs&windows.FILE_ATTRIBUTE_ARCHIVE == 0 {
+		return nil
+	}
+	return windows.SetFileAttributes(pathPtr, attrs&^windows.FILE_ATTRIBUTE_ARCHIVE)
+}
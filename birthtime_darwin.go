@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// setBirthTime gives targetPath the creation time of the source through
+// setattrlist, which APFS and HFS+ support.
+func setBirthTime(targetPath string, sourceInfo os.FileInfo) error {
+	stat, ok := sourceInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	birth := unix.Timespec{Sec: stat.Birthtimespec.Sec, Nsec: stat.Birthtimespec.Nsec}
+	attrs := unix.Attrlist{Bitmapcount: unix.ATTR_BIT_MAP_COUNT, Commonattr: unix.ATTR_CMN_CRTIME}
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(&birth)), unsafe.Sizeof(birth))
+	return unix.Setattrlist(targetPath, &attrs, buf, 0)
+}
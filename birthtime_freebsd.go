@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// setBirthTime gives targetPath the creation time of the source. FreeBSD
+// moves a file's birth time back whenever its mod time is set earlier, so the
+// mod time is set to the birth time first and then restored.
+func setBirthTime(targetPath string, sourceInfo os.FileInfo) error {
+	stat, ok := sourceInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	birth := time.Unix(stat.Birthtimespec.Sec, stat.Birthtimespec.Nsec)
+	err := os.Chtimes(targetPath, accessTime(sourceInfo), birth)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(targetPath, accessTime(sourceInfo), sourceInfo.ModTime())
+}
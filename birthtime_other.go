@@ -0,0 +1,11 @@
+//go:build unix && !darwin && !freebsd
+
+package main
+
+import "os"
+
+// setBirthTime always fails with errBirthTimeUnsupported: Linux and the other
+// Unix systems can read a creation time but offer no call to set it.
+func setBirthTime(targetPath string, sourceInfo os.FileInfo) error {
+	return errBirthTimeUnsupported
+}
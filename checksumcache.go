@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// checksumCacheName is kept next to the executable and remembers source
+// digests between runs, so checksum comparison only re-hashes files whose size
+// or mod time changed
+const checksumCacheName = "checksums.cache"
+
+// cachedChecksum is the digest of a source file as it was when last hashed
+type cachedChecksum struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256"`
+}
+
+// checksumCache maps each job name and relative source path to its cached
+// digest. usedChecksums holds the keys looked up this run, so entries for
+// files that have gone can be dropped.
+var checksumCache map[string]cachedChecksum
+var usedChecksums map[string]bool
+var checksumCachePath string
+var checksumCacheMu sync.Mutex
+
+// loadChecksumCache reads the cache at path. A missing or unreadable cache
+// just means every file is hashed again.
+func loadChecksumCache(path string) {
+	checksumCacheMu.Lock()
+	defer checksumCacheMu.Unlock()
+
+	checksumCachePath = path
+	checksumCache = map[string]cachedChecksum{}
+	usedChecksums = map[string]bool{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if json.Unmarshal(data, &checksumCache) != nil {
+		checksumCache = map[string]cachedChecksum{}
+	}
+}
+
+// saveChecksumCache writes the cache back when this run hashed anything.
+// When prune is set, entries this run never looked up are dropped, which is
+// only safe once every file has been visited.
+func saveChecksumCache(prune bool) error {
+	checksumCacheMu.Lock()
+	defer checksumCacheMu.Unlock()
+
+	if len(usedChecksums) == 0 {
+		return nil
+	}
+	if prune {
+		for key := range checksumCache {
+			if !usedChecksums[key] {
+				delete(checksumCache, key)
+			}
+		}
+	}
+
+	data, err := json.Marshal(checksumCache)
+	if err != nil {
+		return err
+	}
+	// Replace the cache in one step so a crash never leaves half of it
+	tempPath := checksumCachePath + tempFileSuffix
+	err = os.WriteFile(tempPath, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempPath, checksumCachePath)
+}
+
+// sourceChecksum returns the digest of the source file at sourcePath, reusing
+// the cached one while its size and mod time are unchanged.
+func (j *jobRun) sourceChecksum(sourcePath string, info os.FileInfo) (string, error) {
+	// Walk paths carry the \\?\ prefix on Windows, which Rel treats as another volume
+	relPath, err := filepath.Rel(toLongPath(j.SourceDir), toLongPath(sourcePath))
+	if err != nil || checksumCache == nil {
+		return fileChecksum(sourcePath)
+	}
+	key := j.Name + "|" + filepath.ToSlash(relPath)
+
+	checksumCacheMu.Lock()
+	cached, ok := checksumCache[key]
+	checksumCacheMu.Unlock()
+	if ok && cached.Size == info.Size() && cached.ModTime.Equal(info.ModTime()) {
+		checksumCacheMu.Lock()
+		usedChecksums[key] = true
+		checksumCacheMu.Unlock()
+		return cached.SHA256, nil
+	}
+
+	sum, err := fileChecksum(sourcePath)
+	if err != nil {
+		return "", err
+	}
+	checksumCacheMu.Lock()
+	checksumCache[key] = cachedChecksum{Size: info.Size(), ModTime: info.ModTime(), SHA256: sum}
+	usedChecksums[key] = true
+	checksumCacheMu.Unlock()
+	return sum, nil
+}
+
+// targetChecksum returns the SHA-256 of the target file at targetPath,
+// reusing the digest of an earlier comparison in this run while its size and
+// mod time are unchanged, so a pre-scan and the sync hash it only once.
+func (j *jobRun) targetChecksum(targetPath string, info os.FileInfo) (string, error) {
+	if cached, ok := j.targetSums.Load(targetPath); ok {
+		cached := cached.(cachedChecksum)
+		if cached.Size == info.Size() && cached.ModTime.Equal(info.ModTime()) {
+			return cached.SHA256, nil
+		}
+	}
+	sum, err := fileChecksum(targetPath)
+	if err != nil {
+		return "", err
+	}
+	j.targetSums.Store(targetPath, cachedChecksum{Size: info.Size(), ModTime: info.ModTime(), SHA256: sum})
+	return sum, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// checkClockSkew writes a probe file to the job's source and target and
+// compares the mod times their volumes stamp on them. A difference beyond the
+// mod time tolerance makes files the target stamped itself look modified, so
+// it is warned about and, with compensate_clock_skew, taken off comparisons.
+// Since the probes are written, skew is only measured when the run is not a
+// dry run, and a dry run compares without compensation.
+func (j *jobRun) checkClockSkew() {
+	// A target that does not exist yet has nothing to compare against
+	if !fileExists(j.TargetDir) {
+		return
+	}
+	sourceOffset, err := clockOffset(j.SourceDir)
+	if err != nil {
+		j.logDebug(fmt.Sprintf("Could not check the source clock: %v", err))
+		return
+	}
+	targetOffset, err := clockOffset(j.TargetDir)
+	if err != nil {
+		j.logDebug(fmt.Sprintf("Could not check the target clock: %v", err))
+		return
+	}
+
+	skew := sourceOffset - targetOffset
+	if skew.Abs() <= config.modTimeTolerance() {
+		j.logDebug(fmt.Sprintf("Clock skew between source and target: %v", skew))
+		return
+	}
+
+	direction := "ahead of"
+	if skew < 0 {
+		direction = "behind"
+	}
+	message := fmt.Sprintf("Clock skew detected: the source clock is %v %s the target's, so unchanged files may be copied again", skew.Abs().Round(time.Second), direction)
+	if config.CompensateClockSkew {
+		j.clockSkew = skew
+		message += "; compensating"
+	}
+	j.logWarn(message)
+	printError("%sWARNING: %s.", j.prefix(), message)
+}
+
+// clockOffset returns how far the mod time dir's volume stamps on a new file
+// is from the local clock.
+func clockOffset(dir string) (time.Duration, error) {
+	// The temporary suffix keeps a probe left by a crash out of any sync
+	file, err := os.CreateTemp(toLongPath(dir), ".gosync-clock-*"+tempFileSuffix)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+
+	before := time.Now()
+	_, err = file.Write([]byte{0})
+	closeErr := file.Close()
+	after := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	if closeErr != nil {
+		return 0, closeErr
+	}
+
+	info, err := os.Stat(file.Name())
+	if err != nil {
+		return 0, err
+	}
+	written := before.Add(after.Sub(before) / 2)
+	return info.ModTime().Sub(written), nil
+}
+
+// timeDrift returns how much newer the source's mod time is than the
+// target's. Files copied by a sync carry the source's mod time, so times that
+// already match are left alone, and only a target time stamped by the target's
+// own clock has the compensated skew taken off.
+func (j *jobRun) timeDrift(sourceInfo, targetInfo os.FileInfo) time.Duration {
+	drift := sourceInfo.ModTime().Sub(targetInfo.ModTime())
+	if j.clockSkew == 0 || drift.Abs() <= config.modTimeTolerance() {
+		return drift
+	}
+	return drift - j.clockSkew
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeDrift(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		skew         time.Duration
+		sourceOffset time.Duration
+		want         time.Duration
+	}{
+		{name: "no skew", sourceOffset: time.Hour, want: time.Hour},
+		{name: "no skew, target newer", sourceOffset: -time.Hour, want: -time.Hour},
+		{name: "skew taken off", skew: time.Hour, sourceOffset: time.Hour, want: 0},
+		{name: "skew taken off a real change", skew: time.Hour, sourceOffset: 3 * time.Hour, want: 2 * time.Hour},
+		{name: "matching times left alone", skew: time.Hour, want: 0},
+		{name: "times within tolerance left alone", skew: time.Hour, sourceOffset: time.Second, want: time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, Config{})
+			job := &jobRun{clockSkew: test.skew}
+			source := fakeInfo{name: "a", modTime: modTime.Add(test.sourceOffset)}
+			target := fakeInfo{name: "a", modTime: modTime}
+			if got := job.timeDrift(source, target); got != test.want {
+				t.Errorf("timeDrift() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Case collision policies for source files whose target paths differ only in case
+const (
+	collisionSkip   = "skip"
+	collisionRename = "rename"
+	collisionOff    = "off"
+)
+
+// caseCollisionPolicy returns the case_collision setting. When unset,
+// collisions are skipped on platforms whose file systems ignore case and not
+// checked elsewhere.
+func caseCollisionPolicy() string {
+	if config.CaseCollision != "" {
+		return config.CaseCollision
+	}
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		return collisionSkip
+	}
+	return collisionOff
+}
+
+// collisionKey folds the path of a file within the target to the form a
+// case-insensitive file system compares.
+func (j *jobRun) collisionKey(targetPath string) string {
+	relPath, err := filepath.Rel(j.TargetDir, targetPath)
+	if err != nil {
+		relPath = targetPath
+	}
+	return strings.ToLower(relPath)
+}
+
+// claimTarget records relPath as a candidate for its target path while the
+// source is counted. The smallest relative path wins, so the same file keeps
+// the name from one run to the next however the workers are scheduled.
+func (j *jobRun) claimTarget(relPath string) {
+	if caseCollisionPolicy() == collisionOff {
+		return
+	}
+	key := j.collisionKey(j.targetPath(relPath))
+
+	j.ownersMu.Lock()
+	defer j.ownersMu.Unlock()
+	if j.owners == nil {
+		j.owners = map[string]string{}
+	}
+	owner, ok := j.owners[key]
+	if !ok || relPath < owner {
+		j.owners[key] = relPath
+	}
+}
+
+// resolveCollision returns where the file at relPath should be copied, which
+// is targetPath unless another source file already owns that path in a
+// different case. It returns false when the file should be skipped instead.
+func (j *jobRun) resolveCollision(relPath, targetPath string) (string, bool) {
+	policy := caseCollisionPolicy()
+	if policy == collisionOff {
+		return targetPath, true
+	}
+	key := j.collisionKey(targetPath)
+
+	j.ownersMu.Lock()
+	defer j.ownersMu.Unlock()
+	if j.owners == nil {
+		j.owners = map[string]string{}
+	}
+	owner, ok := j.owners[key]
+	if !ok {
+		// Files that appeared after the count, such as in watch mode
+		j.owners[key] = relPath
+		return targetPath, true
+	}
+	if owner == relPath {
+		return targetPath, true
+	}
+
+	if policy == collisionSkip {
+		j.logWarn(fmt.Sprintf("Case collision, skipping %s: it has the same target as %s", relPath, owner))
+		return "", false
+	}
+	renamed := collisionName(targetPath, relPath)
+	if j.renamed == nil {
+		j.renamed = map[string]bool{}
+	}
+	j.renamed[j.collisionKey(renamed)] = true
+	j.logWarn(fmt.Sprintf("Case collision with %s, copying %s as %s", owner, relPath, filepath.Base(renamed)))
+	return renamed, true
+}
+
+// isRenamedCollision reports whether the file at targetRel under the target
+// is the renamed copy of a case collision, so mirror keeps it.
+func (j *jobRun) isRenamedCollision(targetRel string) bool {
+	j.ownersMu.Lock()
+	defer j.ownersMu.Unlock()
+	return j.renamed[strings.ToLower(targetRel)]
+}
+
+// collisionName inserts a short hash of relPath before the extension of
+// targetPath, e.g. report.txt becomes report~1a2b3c4d.txt. Hashing the source
+// path keeps the name the same on every run.
+func collisionName(targetPath, relPath string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(relPath))
+	ext := filepath.Ext(targetPath)
+	return fmt.Sprintf("%s~%08x%s", strings.TrimSuffix(targetPath, ext), hash.Sum32(), ext)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveCollision(t *testing.T) {
+	targetDir := filepath.Join("backup", "target")
+	tests := []struct {
+		policy  string
+		relPath string
+		want    string
+		wantOK  bool
+	}{
+		{policy: collisionSkip, relPath: "Report.txt", want: filepath.Join(targetDir, "Report.txt"), wantOK: true},
+		{policy: collisionSkip, relPath: "report.txt", wantOK: false},
+		{policy: collisionRename, relPath: "Report.txt", want: filepath.Join(targetDir, "Report.txt"), wantOK: true},
+		{policy: collisionRename, relPath: "report.txt", want: collisionName(filepath.Join(targetDir, "report.txt"), "report.txt"), wantOK: true},
+		{policy: collisionOff, relPath: "report.txt", want: filepath.Join(targetDir, "report.txt"), wantOK: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy+" "+test.relPath, func(t *testing.T) {
+			useConfig(t, Config{CaseCollision: test.policy})
+			job := &jobRun{Job: Job{TargetDir: targetDir}}
+			// The walk claims both names before either is copied
+			job.claimTarget("report.txt")
+			job.claimTarget("Report.txt")
+
+			got, ok := job.resolveCollision(test.relPath, job.targetPath(test.relPath))
+			if got != test.want || ok != test.wantOK {
+				t.Errorf("resolveCollision(%q) = %q, %v, want %q, %v", test.relPath, got, ok, test.want, test.wantOK)
+			}
+			if test.policy == collisionRename && got != job.targetPath(test.relPath) {
+				rel, _ := filepath.Rel(targetDir, got)
+				if !job.isRenamedCollision(rel) {
+					t.Errorf("isRenamedCollision(%q) = false, want true so mirror keeps it", rel)
+				}
+			}
+		})
+	}
+}
+
+func TestCollisionNameStable(t *testing.T) {
+	first := collisionName(filepath.Join("t", "report.txt"), "report.txt")
+	if first != collisionName(filepath.Join("t", "report.txt"), "report.txt") {
+		t.Error("collisionName() changed between calls")
+	}
+	if first == collisionName(filepath.Join("t", "report.txt"), "REPORT.txt") {
+		t.Error("collisionName() gave two sources the same name")
+	}
+	if filepath.Ext(first) != ".txt" {
+		t.Errorf("collisionName() = %q, want the .txt extension kept", first)
+	}
+}
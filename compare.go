@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"gopkg.in/yaml.v3"
+)
+
+// compareRule applies a comparison strategy to files matching pattern. A rule
+// without a pattern sets the default for files no other rule matches.
+type compareRule struct {
+	Pattern  string `json:"pattern" yaml:"pattern"`
+	Strategy string `json:"strategy" yaml:"strategy"`
+}
+
+// compareSetting is the compare config value, which is either a single
+// strategy for every file or a list of rules tried in order
+type compareSetting struct {
+	Default string
+	Rules   []compareRule
+}
+
+func (c *compareSetting) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		return json.Unmarshal(data, &c.Default)
+	}
+	return c.setRules(json.Unmarshal(data, &c.Rules))
+}
+
+// MarshalJSON writes the setting back in the form it was read, with the
+// default as a pattern-less last rule when there are rules.
+func (c compareSetting) MarshalJSON() ([]byte, error) {
+	if len(c.Rules) == 0 {
+		return json.Marshal(c.Default)
+	}
+	rules := append([]compareRule(nil), c.Rules...)
+	if c.Default != "" {
+		rules = append(rules, compareRule{Strategy: c.Default})
+	}
+	return json.Marshal(rules)
+}
+
+func (c *compareSetting) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&c.Default)
+	}
+	return c.setRules(value.Decode(&c.Rules))
+}
+
+// setRules moves a pattern-less rule out of Rules into Default once they are decoded.
+func (c *compareSetting) setRules(err error) error {
+	if err != nil {
+		return err
+	}
+	rules := c.Rules[:0]
+	for _, rule := range c.Rules {
+		if rule.Pattern == "" {
+			c.Default = rule.Strategy
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	c.Rules = rules
+	return nil
+}
+
+// strategies lists every strategy named in the setting, for validation.
+func (c compareSetting) strategies() []string {
+	names := []string{c.Default}
+	for _, rule := range c.Rules {
+		names = append(names, rule.Strategy)
+	}
+	return names
+}
+
+// strategyFor returns the strategy of the first rule matching relPath, or
+// the default, which is size when unset.
+func (c compareSetting) strategyFor(relPath string) string {
+	for _, rule := range c.Rules {
+		if matchPattern(rule.Pattern, relPath) {
+			return rule.Strategy
+		}
+	}
+	if c.Default == "" {
+		return compareSize
+	}
+	return c.Default
+}
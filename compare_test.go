@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestCompareSettingDecode(t *testing.T) {
+	rules := []compareRule{{Pattern: "*.psd", Strategy: compareChecksum}}
+	tests := []struct {
+		name       string
+		json, yaml string
+		want       compareSetting
+	}{
+		{name: "single strategy", json: `"checksum"`, yaml: `checksum`, want: compareSetting{Default: compareChecksum}},
+		{name: "rules", json: `[{"pattern": "*.psd", "strategy": "checksum"}]`, yaml: "- pattern: '*.psd'\n  strategy: checksum", want: compareSetting{Rules: rules}},
+		{name: "rules with default", json: `[{"pattern": "*.psd", "strategy": "checksum"}, {"strategy": "modtime"}]`, yaml: "- pattern: '*.psd'\n  strategy: checksum\n- strategy: modtime", want: compareSetting{Default: compareModTime, Rules: rules}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var fromJSON, fromYAML compareSetting
+			if err := json.Unmarshal([]byte(test.json), &fromJSON); err != nil {
+				t.Fatal(err)
+			}
+			if err := yaml.Unmarshal([]byte(test.yaml), &fromYAML); err != nil {
+				t.Fatal(err)
+			}
+			for _, got := range []compareSetting{fromJSON, fromYAML} {
+				if got.Default != test.want.Default || !slices.Equal(got.Rules, test.want.Rules) {
+					t.Errorf("decoded %+v, want %+v", got, test.want)
+				}
+			}
+
+			// Printing the config must give back what was read
+			data, err := json.Marshal(fromJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var again compareSetting
+			if err := json.Unmarshal(data, &again); err != nil {
+				t.Fatal(err)
+			}
+			if again.Default != test.want.Default || !slices.Equal(again.Rules, test.want.Rules) {
+				t.Errorf("round trip through %s gave %+v, want %+v", data, again, test.want)
+			}
+		})
+	}
+}
+
+func TestStrategyFor(t *testing.T) {
+	setting := compareSetting{Default: compareModTime, Rules: []compareRule{
+		{Pattern: "*.psd", Strategy: compareChecksum},
+		{Pattern: filepath.Join("logs", "*"), Strategy: compareSize},
+	}}
+	tests := []struct {
+		relPath string
+		want    string
+	}{
+		{relPath: "a.txt", want: compareModTime},
+		{relPath: filepath.Join("art", "a.psd"), want: compareChecksum},
+		{relPath: filepath.Join("logs", "a.txt"), want: compareSize},
+		{relPath: filepath.Join("old", "logs", "a.txt"), want: compareModTime},
+	}
+
+	for _, test := range tests {
+		if got := setting.strategyFor(test.relPath); got != test.want {
+			t.Errorf("strategyFor(%q) = %q, want %q", test.relPath, got, test.want)
+		}
+	}
+	if got := (compareSetting{}).strategyFor("a.txt"); got != compareSize {
+		t.Errorf("strategyFor() with nothing set = %q, want %q", got, compareSize)
+	}
+}
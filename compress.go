@@ -0,0 +1,53 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+)
+
+// gzipSuffix is added to target file names when compress is set
+const gzipSuffix = ".gz"
+
+// compressedName returns relPath with gzipSuffix added when compress is set.
+// Files that are already gzipped are copied as they are.
+func compressedName(relPath string) string {
+	if !config.Compress || strings.HasSuffix(strings.ToLower(relPath), gzipSuffix) {
+		return relPath
+	}
+	return relPath + gzipSuffix
+}
+
+// compressesTo reports whether copying sourcePath to targetPath should gzip
+// the data, which is when compress added the suffix to the target name.
+func compressesTo(sourcePath, targetPath string) bool {
+	return config.Compress &&
+		strings.HasSuffix(strings.ToLower(targetPath), gzipSuffix) &&
+		!strings.HasSuffix(strings.ToLower(sourcePath), gzipSuffix)
+}
+
+// gzipChecksum returns the hex encoded SHA-256 digest of the decompressed
+// contents of the gzip file at path.
+func gzipChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, reader)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// copyDirection is which way a file should be copied between source and target
+type copyDirection int
+
+const (
+	copyNone copyDirection = iota
+	copyToTarget
+	copyToSource
+)
+
+// Conflict policies for files that exist on both sides and differ
+const (
+	conflictNewest = "newest"
+	conflictSource = "source"
+	conflictTarget = "target"
+	conflictSkip   = "skip"
+)
+
+// filesDiffer reports whether source and target no longer match, using the
+// same mod time, size and checksum rules as a normal sync.
+func filesDiffer(task syncTask, targetPath string, targetInfo os.FileInfo) (bool, error) {
+	job, sourcePath, sourceInfo := task.job, task.path, task.info
+	drift := job.timeDrift(sourceInfo, targetInfo)
+	if drift.Abs() > config.modTimeTolerance() {
+		return true, nil
+	}
+	mode := config.Compare.strategyFor(task.relPath)
+	if mode == compareModTime || config.Compress {
+		return false, nil
+	}
+	if sourceInfo.Size() != targetInfo.Size() {
+		return true, nil
+	}
+	if mode != compareChecksum {
+		return false, nil
+	}
+	return checksumsDiffer(job, sourcePath, targetPath, sourceInfo, targetInfo)
+}
+
+// resolveConflict applies the conflict policy to a file that differs between
+// source and target, returning the direction and a warning to log when both
+// copies are left alone.
+func (j *jobRun) resolveConflict(targetPath string, sourceInfo, targetInfo os.FileInfo) (copyDirection, string) {
+	switch config.Conflict {
+	case conflictSource:
+		return copyToTarget, ""
+	case conflictTarget:
+		return copyToSource, ""
+	case conflictNewest:
+		drift := j.timeDrift(sourceInfo, targetInfo)
+		tolerance := config.modTimeTolerance()
+		if drift > tolerance {
+			return copyToTarget, ""
+		}
+		if -drift > tolerance {
+			return copyToSource, ""
+		}
+		return copyNone, fmt.Sprintf("Conflict with matching mod times, leaving both copies: %s", targetPath)
+	default:
+		return copyNone, fmt.Sprintf("Conflict, leaving both copies: %s", targetPath)
+	}
+}
+
+// copyBackToSource copies the target over the source for a conflict the target won.
+func (j *jobRun) copyBackToSource(ctx context.Context, task syncTask, targetPath string, targetInfo os.FileInfo, dryRun bool) {
+	if dryRun {
+		j.logInfo(fmt.Sprintf("Would copy to source: %s", targetPath))
+		wouldCopyFiles.Add(1)
+		wouldCopyBytes.Add(targetInfo.Size())
+		emitEvent(eventWouldCopy, targetPath, targetInfo.Size(), nil)
+		return
+	}
+
+	_, err := copyWithRetry(ctx, j, targetPath, task.path, "", targetInfo)
+	if err != nil {
+		j.logError(fmt.Sprintf("Error copying file to source: %v", err))
+		j.countError()
+		emitEvent(eventError, targetPath, 0, err)
+		return
+	}
+	j.stats.copied.Add(1)
+	j.stats.bytesCopied.Add(targetInfo.Size())
+	j.logInfo(fmt.Sprintf("Copied to source: %s", filepath.Base(task.path)))
+	emitEvent(eventCopyToSource, targetPath, targetInfo.Size(), nil)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCopyBackToSource(t *testing.T) {
+	useConfig(t, Config{Conflict: conflictNewest})
+	job := newTestJob(t, nil, nil)
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	writeFile(t, filepath.Join(job.SourceDir, "a.txt"), "old", modTime)
+	writeFile(t, filepath.Join(job.TargetDir, "a.txt"), "edited", modTime.Add(time.Hour))
+	syncTree(t, job)
+
+	content, err := os.ReadFile(filepath.Join(job.SourceDir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "edited" {
+		t.Errorf("source holds %q, want the newer target's %q", content, "edited")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// ANSI sequences used by --color
+const (
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiGray  = "\x1b[90m"
+	ansiReset = "\x1b[0m"
+)
+
+// consoleQuiet hides everything but errors and the final summary, and
+// consoleColor highlights copies, skips and errors
+var consoleQuiet, consoleColor bool
+
+// consoleOut receives the console output, which moves to stderr when
+// --events-json takes over stdout
+var consoleOut io.Writer = os.Stdout
+
+// colorize wraps text in the given ANSI color when --color is active.
+func colorize(color, text string) string {
+	if !consoleColor {
+		return text
+	}
+	return color + text + ansiReset
+}
+
+// printStatus prints a progress line, unless --quiet is set.
+func printStatus(format string, args ...any) {
+	if consoleQuiet {
+		return
+	}
+	fmt.Fprintln(consoleOut, fmt.Sprintf(format, args...))
+}
+
+// printSummary prints a line of the end-of-run summary, which --quiet keeps.
+func printSummary(format string, args ...any) {
+	fmt.Fprintln(consoleOut, fmt.Sprintf(format, args...))
+}
+
+// printError prints an error line in red. Errors are always shown.
+func printError(format string, args ...any) {
+	fmt.Fprintln(consoleOut, colorize(ansiRed, fmt.Sprintf(format, args...)))
+}
+
+// errorCountText formats an error count for the summary, in red when it is not zero.
+func errorCountText(errors int64) string {
+	text := fmt.Sprintf("errors %d", errors)
+	if errors == 0 {
+		return text
+	}
+	return colorize(ansiRed, text)
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package main
+
+import "os"
+
+// isTerminal reports whether stdout is a terminal rather than a file or pipe.
+func isTerminal() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// enableColor reports whether stdout is a terminal that can show ANSI colors.
+func enableColor() bool {
+	return isTerminal() && os.Getenv("TERM") != "dumb"
+}
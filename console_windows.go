@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// isTerminal reports whether stdout is a console rather than a file or pipe.
+func isTerminal() bool {
+	var mode uint32
+	return windows.GetConsoleMode(windows.Handle(os.Stdout.Fd()), &mode) == nil
+}
+
+// enableColor reports whether stdout is a console that can show ANSI colors,
+// turning on virtual terminal processing so it interprets them.
+func enableColor() bool {
+	handle := windows.Handle(os.Stdout.Fd())
+	var mode uint32
+	if windows.GetConsoleMode(handle, &mode) != nil {
+		// Redirected to a file or pipe
+		return false
+	}
+	return windows.SetConsoleMode(handle, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING) == nil
+}
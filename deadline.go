@@ -0,0 +1,19 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// filesRemaining counts the files that still needed copying when the soft
+// deadline stopped new copies from starting
+var filesRemaining atomic.Int64
+
+// pastSoftDeadline reports whether soft_deadline_seconds have passed since
+// the run started at start. Copies already running are left to finish.
+func pastSoftDeadline(start time.Time) bool {
+	if config.SoftDeadlineSeconds <= 0 {
+		return false
+	}
+	return time.Since(start) > time.Duration(config.SoftDeadlineSeconds)*time.Second
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// contentDigest returns the SHA-256 of the source file for dedupe, or "" when
+// it cannot be hashed, in which case the file is simply copied.
+func (j *jobRun) contentDigest(task syncTask) string {
+	digest, err := j.sourceChecksum(task.path, task.info)
+	if err != nil {
+		j.logDebug(fmt.Sprintf("Could not hash %s for dedupe: %v", task.path, err))
+		return ""
+	}
+	return digest
+}
+
+// indexContent records the content of the file's target during the pre-scan
+// when the target is already up to date, so duplicates copied later link to
+// it whatever order the workers reach them in.
+func (j *jobRun) indexContent(task syncTask) {
+	targetPath := j.targetPath(task.relPath)
+	decision := decideCopy(task, targetPath)
+	if decision.direction == copyNone && decision.targetInfo != nil {
+		j.rememberContent(j.contentDigest(task), targetPath)
+	}
+}
+
+// contentCopy is the first target path to be given a piece of content. done
+// is closed once it is known whether that path holds it.
+type contentCopy struct {
+	targetPath string
+	done       chan struct{}
+	copied     bool
+}
+
+// rememberContent records that the target file at targetPath holds the data
+// with the given digest, so later files with the same content can link to it.
+func (j *jobRun) rememberContent(digest, targetPath string) {
+	if digest == "" {
+		return
+	}
+	j.contentMu.Lock()
+	defer j.contentMu.Unlock()
+	if j.content == nil {
+		j.content = make(map[string]*contentCopy)
+	}
+	if _, seen := j.content[digest]; !seen {
+		done := make(chan struct{})
+		close(done)
+		j.content[digest] = &contentCopy{targetPath: targetPath, done: done, copied: true}
+	}
+}
+
+// claimContent registers targetPath as the copy of the data with the given
+// digest when it is the first file with that content, reporting first, so
+// identical files copied at the same time still become links. Otherwise it
+// waits for that copy and returns a target file other than targetPath that
+// holds the data, or "" when there is none.
+func (j *jobRun) claimContent(digest, targetPath string) (origin string, first bool) {
+	if digest == "" {
+		return "", false
+	}
+	j.contentMu.Lock()
+	entry, seen := j.content[digest]
+	if !seen {
+		if j.content == nil {
+			j.content = make(map[string]*contentCopy)
+		}
+		j.content[digest] = &contentCopy{targetPath: targetPath, done: make(chan struct{})}
+	}
+	j.contentMu.Unlock()
+
+	if !seen {
+		return "", true
+	}
+	<-entry.done
+	if !entry.copied || filepath.Clean(entry.targetPath) == filepath.Clean(targetPath) || !fileExists(entry.targetPath) {
+		return "", false
+	}
+	return entry.targetPath, false
+}
+
+// finishContent records whether the copy claimed for digest succeeded and
+// releases the files waiting on it. A failed copy gives up the claim, so the
+// next file with the content is copied in its place.
+func (j *jobRun) finishContent(digest string, copied bool) {
+	j.contentMu.Lock()
+	entry := j.content[digest]
+	if !copied {
+		delete(j.content, digest)
+	}
+	j.contentMu.Unlock()
+	entry.copied = copied
+	close(entry.done)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Statuses written to the --diff report
+const (
+	diffNew     = "NEW"
+	diffChanged = "CHANGED"
+	diffOrphan  = "ORPHAN"
+	diffSame    = "SAME"
+)
+
+// diffRow is one file in the --diff report. A nil info means the file is
+// missing on that side.
+type diffRow struct {
+	relPath    string
+	status     string
+	sourceInfo os.FileInfo
+	targetInfo os.FileInfo
+}
+
+// writeDiffReport compares every job's source and target without copying and
+// writes a CSV of new, changed, orphaned and unchanged files to path. Files
+// count as changed by the same rules a sync uses to decide what to copy.
+func writeDiffReport(ctx context.Context, path string) error {
+	var rows []diffRow
+	for _, job := range jobs {
+		var jobRows []diffRow
+		var rowsMu sync.Mutex
+		walkSource(ctx, job, false, func(task syncTask) {
+			if !task.info.Mode().IsRegular() {
+				return
+			}
+			row := diffRow{relPath: job.prefix() + task.relPath, status: diffSame, sourceInfo: task.info}
+			direction, targetInfo := copyDirectionFor(task, job.targetPath(task.relPath))
+			row.targetInfo = targetInfo
+			if targetInfo == nil {
+				row.status = diffNew
+			} else if direction != copyNone {
+				row.status = diffChanged
+			}
+			rowsMu.Lock()
+			jobRows = append(jobRows, row)
+			rowsMu.Unlock()
+		})
+
+		err := job.walkOrphans(func(path, relPath string, info os.FileInfo) {
+			jobRows = append(jobRows, diffRow{relPath: job.prefix() + relPath, status: diffOrphan, targetInfo: info})
+		})
+		if err != nil {
+			return err
+		}
+
+		// The walk visits files in no particular order
+		sort.Slice(jobRows, func(a, b int) bool { return jobRows[a].relPath < jobRows[b].relPath })
+		rows = append(rows, jobRows...)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"relative_path", "status", "source_size", "target_size", "source_mtime", "target_mtime"})
+	for _, row := range rows {
+		sourceSize, sourceTime := diffColumns(row.sourceInfo)
+		targetSize, targetTime := diffColumns(row.targetInfo)
+		writer.Write([]string{filepath.ToSlash(row.relPath), row.status, sourceSize, targetSize, sourceTime, targetTime})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// diffColumns formats the size and mod time of one side of a diff row,
+// leaving both empty when the file is missing there.
+func diffColumns(info os.FileInfo) (string, string) {
+	if info == nil {
+		return "", ""
+	}
+	return strconv.FormatInt(info.Size(), 10), info.ModTime().UTC().Format(time.RFC3339)
+}
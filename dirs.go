@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// removeFile deletes path, clearing a read-only bit that would block it first.
+func removeFile(path string) error {
+	err := clearFileAttributes(path)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// removeEmptyDirs deletes every empty directory below root, children before
+// parents, calling removed for each one. Root itself is kept, as is any
+// directory keep reports true for when keep is set. It returns how many
+// directories were removed.
+func removeEmptyDirs(root string, keep func(relPath string) bool, removed func(dir string)) int {
+	root = toLongPath(root)
+	var dirs []string
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() || path == root {
+			return nil
+		}
+		if keep != nil {
+			relPath, err := filepath.Rel(root, path)
+			if err != nil || keep(relPath) {
+				return nil
+			}
+		}
+		dirs = append(dirs, path)
+		return nil
+	})
+
+	// Reverse order puts every child ahead of its parent
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+
+	count := 0
+	for _, dir := range dirs {
+		// os.Remove refuses directories that still have entries
+		if os.Remove(dir) == nil {
+			removed(dir)
+			count++
+		}
+	}
+	return count
+}
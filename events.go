@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Actions reported by --events-json
+const (
+	eventCopy         = "copy"
+	eventMove         = "move"
+	eventLink         = "link"
+	eventCopyToSource = "copy_to_source"
+	eventSkip         = "skip"
+	eventWouldCopy    = "would_copy"
+	eventDelete       = "delete"
+	eventWouldDelete  = "would_delete"
+	eventError        = "error"
+)
+
+// eventsJSON writes a JSON line to stdout for every file processed, moving
+// the human-readable console output to stderr
+var eventsJSON bool
+
+// fileEvent is one line of the --events-json stream
+type fileEvent struct {
+	TS     time.Time `json:"ts"`
+	Action string    `json:"action"`
+	Path   string    `json:"path"`
+	Bytes  int64     `json:"bytes"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// emitEvent writes a file event to stdout when --events-json is set. It holds
+// the log mutex so lines from concurrent workers never interleave.
+func emitEvent(action, path string, bytes int64, err error) {
+	if !eventsJSON {
+		return
+	}
+	event := fileEvent{TS: time.Now().UTC(), Action: action, Path: path, Bytes: bytes}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	line, _ := json.Marshal(event)
+
+	mu.Lock()
+	defer mu.Unlock()
+	os.Stdout.Write(append(line, '\n'))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fileList holds the relative paths read from file_list for the current cycle
+var fileList []string
+
+// loadFileList reads the relative paths, one per line, from the file_list at
+// path. Blank lines and lines starting with # are skipped, and a path that
+// would leave the source is refused.
+func loadFileList(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var list []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		relPath := filepath.Clean(filepath.FromSlash(line))
+		if filepath.IsAbs(relPath) || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("%s is not a path relative to the source", line)
+		}
+		list = append(list, relPath)
+	}
+	return list, scanner.Err()
+}
+
+// visitFileList calls visit for each file_list entry found in the job's
+// source, in place of walking the whole tree. Entries go through the same
+// directory and file filters as a walk would apply. Listed paths that are
+// missing or not files are reported when record is set.
+func visitFileList(ctx context.Context, job *jobRun, record bool, visit func(syncTask)) {
+	for _, relPath := range fileList {
+		if ctx.Err() != nil {
+			return
+		}
+
+		// A walk would never have entered an excluded directory above the file
+		if reason := job.parentSkipReason(relPath); reason != "" {
+			if record {
+				job.logDebug(fmt.Sprintf("Skipped %s: %s", reason, relPath))
+			}
+			continue
+		}
+
+		path := filepath.Join(toLongPath(job.SourceDir), relPath)
+		info, err := os.Stat(path)
+		if err == nil && !info.Mode().IsRegular() {
+			err = fmt.Errorf("not a file")
+		}
+		if err != nil {
+			if record {
+				message := fmt.Sprintf("Listed file not copied: %s: %v", relPath, err)
+				if os.IsNotExist(err) {
+					message = fmt.Sprintf("Listed file not in source: %s", relPath)
+				}
+				job.logWarn(message)
+				printError("%s%s", job.prefix(), message)
+			}
+			continue
+		}
+
+		if record {
+			for _, target := range job.group() {
+				target.stats.scanned.Add(1)
+			}
+		}
+		if reason := job.fileSkipReason(relPath, info); reason != "" {
+			if record {
+				job.logDebug(fmt.Sprintf("Skipped by %s: %s", reason, relPath))
+				for _, target := range job.group() {
+					target.stats.skipped.Add(1)
+				}
+			}
+			continue
+		}
+		visit(syncTask{job: job, path: path, relPath: relPath, info: info})
+	}
+}
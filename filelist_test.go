@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLoadFileList(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr bool
+	}{
+		{name: "paths", content: "a.txt\ndocs/b.txt\n", want: []string{"a.txt", filepath.Join("docs", "b.txt")}},
+		{name: "blank lines and comments", content: "# wanted\n\n  a.txt  \n", want: []string{"a.txt"}},
+		{name: "cleaned", content: "./docs//b.txt\n", want: []string{filepath.Join("docs", "b.txt")}},
+		{name: "leaves the source", content: "../a.txt\n", wantErr: true},
+		{name: "absolute", content: filepath.Join(t.TempDir(), "a.txt") + "\n", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "list.txt")
+			if err := os.WriteFile(path, []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := loadFileList(path)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("loadFileList() = %v, want error %v", err, test.wantErr)
+			}
+			if !test.wantErr && !slices.Equal(got, test.want) {
+				t.Errorf("loadFileList() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestVisitFileList(t *testing.T) {
+	useConfig(t, Config{Exclude: []string{"*.log", "node_modules"}})
+	job := newTestJob(t, []string{filepath.Join("docs", "a.txt"), filepath.Join("logs", "b.log"), filepath.Join("node_modules", "c.js")}, nil)
+	saved := fileList
+	fileList = []string{filepath.Join("docs", "a.txt"), filepath.Join("logs", "b.log"), filepath.Join("node_modules", "c.js"), "missing.txt", "docs"}
+	t.Cleanup(func() { fileList = saved })
+
+	var got []string
+	visitFileList(context.Background(), job, true, func(task syncTask) {
+		got = append(got, task.relPath)
+	})
+	if want := []string{filepath.Join("docs", "a.txt")}; !slices.Equal(got, want) {
+		t.Errorf("visitFileList() visited %q, want %q", got, want)
+	}
+	// Only the listed files that exist and sit in included directories are scanned
+	if scanned, skipped := job.stats.scanned.Load(), job.stats.skipped.Load(); scanned != 2 || skipped != 1 {
+		t.Errorf("scanned %d and skipped %d, want 2 and 1", scanned, skipped)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// fixTimes walks every job and, for each target file whose content matches
+// its source by size and SHA-256 but whose mod time does not, copies the
+// source times onto it without copying the content again. It returns how
+// many files were fixed and how many were left for a sync because their
+// content differs.
+func fixTimes(ctx context.Context, dryRun bool) (int64, int64) {
+	var fixed, differ atomic.Int64
+	for _, job := range jobs {
+		walkSource(ctx, job, false, func(task syncTask) {
+			// A compressed copy never matches its source byte for byte
+			if !task.info.Mode().IsRegular() || compressesTo(task.path, job.targetPath(task.relPath)) {
+				return
+			}
+			targetPath := job.targetPath(task.relPath)
+			targetInfo, err := os.Stat(toLongPath(targetPath))
+			if err != nil || targetInfo.Size() != task.info.Size() {
+				return
+			}
+			if task.info.ModTime().Sub(targetInfo.ModTime()).Abs() <= config.modTimeTolerance() {
+				return
+			}
+
+			sourceSum, err := fileChecksum(task.path)
+			if err != nil {
+				job.logError(fmt.Sprintf("Error hashing %s: %v", task.path, err))
+				job.countError()
+				return
+			}
+			targetSum, err := fileChecksum(toLongPath(targetPath))
+			if err != nil {
+				job.logError(fmt.Sprintf("Error hashing %s: %v", targetPath, err))
+				job.countError()
+				return
+			}
+			if sourceSum != targetSum {
+				differ.Add(1)
+				job.logDebug(fmt.Sprintf("Content differs, left for a sync: %s", task.relPath))
+				return
+			}
+
+			if dryRun {
+				fixed.Add(1)
+				job.logInfo(fmt.Sprintf("Would fix times: %s", task.relPath))
+				return
+			}
+			err = setFileTimes(targetPath, task.info)
+			if err != nil {
+				job.logError(fmt.Sprintf("Error setting file times: %v", err))
+				job.countError()
+				return
+			}
+			fixed.Add(1)
+			job.logInfo(fmt.Sprintf("Fixed times: %s", task.relPath))
+		})
+	}
+	return fixed.Load(), differ.Load()
+}
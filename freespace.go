@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// checkFreeSpace sums the sizes of the files each job would copy and compares
+// them against the free space on its target volume plus the configured margin.
+// It returns the bytes to copy across all jobs, and false when a target is
+// short of space, unless this is a dry run in which case the shortfall is only
+// reported.
+func checkFreeSpace(ctx context.Context, dryRun bool) (int64, bool) {
+	margin := uint64(config.FreeSpaceMarginMB) * 1024 * 1024
+	enough := true
+	total := int64(0)
+
+	// Further target_dirs are weighed up during the first target's walk
+	pending := make(map[*jobRun]*atomic.Uint64, len(jobs))
+	for _, job := range jobs {
+		pending[job] = new(atomic.Uint64)
+	}
+	for _, job := range jobs {
+		if job.leader != nil {
+			continue
+		}
+		walkSource(ctx, job, false, func(task syncTask) {
+			if !task.info.Mode().IsRegular() {
+				return
+			}
+			for _, task := range task.forGroup() {
+				if shouldCopyFile(task, task.job.targetPath(task.relPath)) {
+					pending[task.job].Add(uint64(task.info.Size()))
+				}
+			}
+		})
+	}
+
+	for _, job := range jobs {
+		required := pending[job].Load()
+		total += int64(required)
+		if required == 0 {
+			continue
+		}
+
+		available, err := freeSpace(existingAncestor(job.TargetDir))
+		if err != nil {
+			job.logWarn(fmt.Sprintf("Could not check free space on target: %v", err))
+			continue
+		}
+
+		if required+margin > available {
+			message := fmt.Sprintf("Not enough free space on target: %d bytes needed plus %d margin, %d available", required, margin, available)
+			job.logError(message)
+			printError("%s%s", job.prefix(), message)
+			enough = false
+		}
+	}
+
+	return total, enough || dryRun
+}
+
+// existingAncestor returns path or the closest parent of it that exists, since
+// the target may not have been created yet.
+func existingAncestor(path string) string {
+	for {
+		if fileExists(path) {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
@@ -0,0 +1,10 @@
+//go:build unix && !linux && !darwin && !freebsd
+
+package main
+
+import "errors"
+
+// freeSpace is not implemented here, so the free-space preflight is skipped.
+func freeSpace(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
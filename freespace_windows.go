@@ -0,0 +1,18 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// freeSpace returns the bytes available to the current user on the volume holding path.
+func freeSpace(path string) (uint64, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var available, total, free uint64
+	err = windows.GetDiskFreeSpaceEx(pathPtr, &available, &total, &free)
+	if err != nil {
+		return 0, err
+	}
+	return available, nil
+}
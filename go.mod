@@ -2,6 +2,13 @@ module gosync
 
 go 1.22.3
 
+require (
+	github.com/fsnotify/fsnotify v1.7.0
+	golang.org/x/sys v0.20.0
+	golang.org/x/text v0.15.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
 require (
 	github.com/VividCortex/ewma v1.2.0 // indirect
 	github.com/akavel/rsrc v0.10.2 // indirect
@@ -21,5 +28,4 @@ require (
 	github.com/rivo/uniseg v0.2.0 // indirect
 	github.com/ulikunitz/xz v0.5.9 // indirect
 	github.com/xi2/xz v0.0.0-20171230120015-48954b6210f8 // indirect
-	golang.org/x/sys v0.20.0 // indirect
 )
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// fileID identifies a file independently of the path it was reached by, so
+// hard links to the same data compare equal
+type fileID struct {
+	volume uint64
+	index  uint64
+}
+
+// linkedFile is the first path of a hard-linked file to reach a worker
+type linkedFile struct {
+	targetPath string
+	done       chan struct{}
+	copied     bool
+}
+
+// claimLink registers targetPath as the copy of the file id when it is the
+// first path to it, reporting first. Otherwise it waits for that first copy
+// and returns its target path, or "" when the copy failed.
+func (j *jobRun) claimLink(id fileID, targetPath string) (origin string, first bool) {
+	j.linksMu.Lock()
+	entry, seen := j.links[id]
+	if !seen {
+		if j.links == nil {
+			j.links = make(map[fileID]*linkedFile)
+		}
+		j.links[id] = &linkedFile{targetPath: targetPath, done: make(chan struct{})}
+	}
+	j.linksMu.Unlock()
+
+	if !seen {
+		return "", true
+	}
+	<-entry.done
+	if !entry.copied {
+		return "", false
+	}
+	return entry.targetPath, false
+}
+
+// finishLink records whether the first copy of the file id succeeded and
+// releases the paths waiting on it.
+func (j *jobRun) finishLink(id fileID, copied bool) {
+	j.linksMu.Lock()
+	entry := j.links[id]
+	j.linksMu.Unlock()
+	entry.copied = copied
+	close(entry.done)
+}
+
+// linkFile replaces targetPath with a hard link to origin, going through a
+// temporary name so an existing target is only replaced once the link exists.
+func linkFile(origin, targetPath string) error {
+	origin, targetPath = toLongPath(origin), toLongPath(targetPath)
+	if !config.ForceOverwriteReadonly && isReadOnly(targetPath) {
+		return errReadOnlyTarget
+	}
+	err := os.MkdirAll(filepath.Dir(targetPath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	tempPath := targetPath + tempFileSuffix
+	removeTempFile(tempPath)
+	err = os.Link(origin, tempPath)
+	if err != nil {
+		return err
+	}
+
+	err = clearFileAttributes(targetPath)
+	if err == nil {
+		err = os.Rename(tempPath, targetPath)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+	}
+	return err
+}
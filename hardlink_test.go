@@ -0,0 +1,45 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncHardLinks(t *testing.T) {
+	for _, move := range []bool{false, true} {
+		name := "copy"
+		if move {
+			name = "move"
+		}
+		t.Run(name, func(t *testing.T) {
+			useConfig(t, Config{Move: move})
+			job := newTestJob(t, []string{"a.txt"}, nil)
+			if err := os.Link(filepath.Join(job.SourceDir, "a.txt"), filepath.Join(job.SourceDir, "b.txt")); err != nil {
+				t.Fatal(err)
+			}
+			syncTree(t, job)
+
+			first, err := os.Stat(filepath.Join(job.TargetDir, "a.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, err := os.Stat(filepath.Join(job.TargetDir, "b.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !os.SameFile(first, second) {
+				t.Error("b.txt was copied instead of linked to a.txt")
+			}
+
+			// A linked file counts as placed, so move removes its source too
+			for _, name := range []string{"a.txt", "b.txt"} {
+				if exists := fileExists(filepath.Join(job.SourceDir, name)); exists != !move {
+					t.Errorf("source %s exists = %v under move = %v", name, exists, move)
+				}
+			}
+		})
+	}
+}
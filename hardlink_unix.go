@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// hardLinkID returns the device and inode of the file at path, reporting
+// false when it has no other hard links.
+func hardLinkID(path string, info os.FileInfo) (fileID, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return fileID{}, false
+	}
+	return fileID{volume: uint64(stat.Dev), index: uint64(stat.Ino)}, true
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// hardLinkID returns the volume serial number and file index of the file at
+// path, reporting false when it has no other hard links or cannot be opened.
+func hardLinkID(path string, info os.FileInfo) (fileID, bool) {
+	handle, err := windows.CreateFile(
+		windows.StringToUTF16Ptr(toLongPath(path)),
+		windows.FILE_READ_ATTRIBUTES,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil,
+		windows.OPEN_EXISTING,
+		windows.FILE_FLAG_BACKUP_SEMANTICS,
+		0,
+	)
+	if err != nil {
+		return fileID{}, false
+	}
+	defer windows.CloseHandle(handle)
+
+	var data windows.ByHandleFileInformation
+	err = windows.GetFileInformationByHandle(handle, &data)
+	if err != nil || data.NumberOfLinks < 2 {
+		return fileID{}, false
+	}
+	return fileID{
+		volume: uint64(data.VolumeSerialNumber),
+		index:  uint64(data.FileIndexHigh)<<32 | uint64(data.FileIndexLow),
+	}, true
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// runHook runs command through the platform shell with env added to the
+// environment, logging its output and exit status under name.
+func runHook(ctx context.Context, name, command string, env ...string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), env...)
+
+	logInfo(fmt.Sprintf("Running %s: %s", name, command))
+	output, err := cmd.CombinedOutput()
+	for _, line := range strings.Split(strings.TrimRight(string(output), "\r\n"), "\n") {
+		if line != "" {
+			logInfo(fmt.Sprintf("%s: %s", name, strings.TrimRight(line, "\r")))
+		}
+	}
+	if err != nil {
+		logError(fmt.Sprintf("%s failed: %v", name, err))
+		return err
+	}
+	logInfo(fmt.Sprintf("%s exited with status 0", name))
+	return nil
+}
+
+// runPostCommand runs post_command with the run's totals in the environment.
+func runPostCommand(ctx context.Context, summary runSummary) error {
+	return runHook(ctx, "post_command", config.PostCommand,
+		fmt.Sprintf("GOSYNC_COPIED=%d", summary.FilesCopied),
+		fmt.Sprintf("GOSYNC_ERRORS=%d", summary.Errors),
+		fmt.Sprintf("GOSYNC_BYTES=%d", summary.BytesCopied))
+}
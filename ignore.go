@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFileName is read from the root of each source directory
+const ignoreFileName = ".gosyncignore"
+
+// loadIgnoreFile reads the glob patterns, one per line, from the job's
+// .gosyncignore. Blank lines and lines starting with # are skipped. A missing
+// file is not an error.
+func (j *jobRun) loadIgnoreFile() error {
+	file, err := os.Open(filepath.Join(j.SourceDir, ignoreFileName))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		j.ignore = append(j.ignore, line)
+	}
+	return scanner.Err()
+}
+
+// isIgnored reports whether relPath matches a pattern from the job's ignore file.
+func (j *jobRun) isIgnored(relPath string) bool {
+	for _, pattern := range j.ignore {
+		if matchPattern(pattern, relPath) {
+			return true
+		}
+	}
+	return false
+}
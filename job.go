@@ -0,0 +1,1014 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job is a single source/target pair to sync
+type Job struct {
+	Name      string `json:"name" yaml:"name"`
+	SourceDir string `json:"source_dir" yaml:"source_dir"`
+	TargetDir string `json:"target_dir" yaml:"target_dir"`
+	LogFile   string `json:"log_file" yaml:"log_file"`
+}
+
+// jobRun tracks a job and its counters while it is being synced
+type jobRun struct {
+	Job
+	stats  syncStats
+	ignore []string
+	// logFile is the job's own log_file, written under logMu
+	logMu   sync.Mutex
+	logFile *os.File
+	// dirs are the target directories created for preserve_empty_dirs, and
+	// newDirs the relative paths of those made for copied files
+	dirsMu  sync.Mutex
+	dirs    []syncTask
+	newDirs map[string]bool
+	// targetInSource and sourceInTarget hold the relative path of one
+	// directory inside the other when allow_nested permits it
+	targetInSource string
+	sourceInTarget string
+
+	// followers are the jobs for the further target_dirs, which are fed from
+	// this job's walk so the source is only read once, and leader is the job
+	// feeding them
+	followers []*jobRun
+	leader    *jobRun
+
+	// targetSums caches the digests of target files hashed for comparison
+	// during this run, keyed by target path
+	targetSums sync.Map
+
+	// writableDirs caches the --check-access write probe of each target
+	// directory, so a directory of many files is only probed once
+	writableDirs sync.Map
+
+	// clockSkew is how far the source's clock runs ahead of the target's,
+	// taken off mod time comparisons with compensate_clock_skew
+	clockSkew time.Duration
+
+	// locked holds files that were in use during the main pass, and
+	// stillLocked those still in use when retried after it
+	lockedMu      sync.Mutex
+	lockedRetried bool
+	locked        []syncTask
+	stillLocked   []string
+
+	// links maps each hard-linked source file to the first path copied for it
+	linksMu sync.Mutex
+	links   map[fileID]*linkedFile
+
+	// owners maps each case-folded target path to the source file that gets
+	// it, and renamed holds the copies made under another name instead
+	ownersMu sync.Mutex
+	owners   map[string]string
+	renamed  map[string]bool
+
+	// content maps the digest of each file dedupe has seen in the target to
+	// the first target path given it
+	contentMu sync.Mutex
+	content   map[string]*contentCopy
+}
+
+var jobs []*jobRun
+
+// newJobRuns wraps the configured jobs, naming any unnamed ones when there is
+// more than one so their log lines can be told apart.
+func newJobRuns(list []Job) []*jobRun {
+	runs := make([]*jobRun, len(list))
+	for i, job := range list {
+		if job.Name == "" && len(list) > 1 {
+			job.Name = fmt.Sprintf("job%d", i+1)
+		}
+		runs[i] = &jobRun{Job: job}
+		runs[i].detectNesting()
+	}
+	if len(config.Jobs) == 0 && len(config.TargetDirs) > 1 {
+		runs[0].followers = runs[1:]
+		for _, follower := range runs[1:] {
+			follower.leader = runs[0]
+		}
+	}
+	return runs
+}
+
+// detectNesting records where the source and target sit inside each other, so
+// the walk never copies the target into itself and mirror never deletes the source.
+func (j *jobRun) detectNesting() {
+	sourcePath, err := resolvePath(j.SourceDir)
+	if err != nil {
+		return
+	}
+	targetPath, err := resolvePath(j.TargetDir)
+	if err != nil {
+		return
+	}
+	j.targetInSource, _ = nestedRel(sourcePath, targetPath)
+	j.sourceInTarget, _ = nestedRel(targetPath, sourcePath)
+}
+
+// group returns the jobs that share one walk of the source: the leader
+// followed by the jobs it feeds.
+func (j *jobRun) group() []*jobRun {
+	if j.leader != nil {
+		return j.leader.group()
+	}
+	return append([]*jobRun{j}, j.followers...)
+}
+
+// prefix returns the "[name] " tag used on this job's output, or nothing for an unnamed job.
+func (j *jobRun) prefix() string {
+	if j.Name == "" {
+		return ""
+	}
+	return "[" + j.Name + "] "
+}
+
+// logError, logWarn, logInfo and logDebug write message to the job's log at
+// their level.
+func (j *jobRun) logError(message string) { j.log(levelError, message) }
+func (j *jobRun) logWarn(message string)  { j.log(levelWarn, message) }
+func (j *jobRun) logInfo(message string)  { j.log(levelInfo, message) }
+func (j *jobRun) logDebug(message string) { j.log(levelDebug, message) }
+
+// walkJob walks the job's source directory and queues every file that passes
+// the filters onto the queue once, for the workers to copy to each job in its
+// group. Directories and links are recreated under every target as they are found.
+func walkJob(ctx context.Context, job *jobRun, queue *taskQueue, dryRun bool) {
+	walkSource(ctx, job, true, func(task syncTask) {
+		if task.info.IsDir() {
+			if config.PreserveEmptyDirs && !dryRun {
+				for _, task := range task.forGroup() {
+					task.job.createDir(task)
+				}
+			}
+			return
+		}
+		if task.info.Mode()&os.ModeSymlink != 0 {
+			for _, task := range task.forGroup() {
+				task.job.recreateLink(task, dryRun)
+			}
+			return
+		}
+		queue.push(task)
+	})
+}
+
+// createDir mirrors a source directory in the target so empty folders survive
+// the sync, remembering it so its mod time can be applied afterwards.
+func (j *jobRun) createDir(task syncTask) {
+	err := os.MkdirAll(j.targetDirPath(task.relPath), os.ModePerm)
+	if err != nil {
+		j.logError(fmt.Sprintf("Error creating directory: %v", err))
+		j.countError()
+		return
+	}
+	j.dirsMu.Lock()
+	j.dirs = append(j.dirs, task)
+	j.dirsMu.Unlock()
+}
+
+// makeTargetDirs creates the target directory dir, recording every level of
+// it that did not exist yet so preserve_dir_times can fix up its times.
+func (j *jobRun) makeTargetDirs(dir string) error {
+	var missing []string
+	for path := dir; !fileExists(path); path = filepath.Dir(path) {
+		relPath, err := filepath.Rel(j.TargetDir, path)
+		if err != nil || relPath == "." || strings.HasPrefix(relPath, "..") {
+			break
+		}
+		missing = append(missing, relPath)
+	}
+
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	j.dirsMu.Lock()
+	defer j.dirsMu.Unlock()
+	if j.newDirs == nil {
+		j.newDirs = map[string]bool{}
+	}
+	for _, relPath := range missing {
+		j.newDirs[relPath] = true
+	}
+	return nil
+}
+
+// applyDirTimes copies source mod times onto the directories made by createDir,
+// and with preserve_dir_times onto those made for copied files. It runs after
+// every file is written, since adding files changes a directory's mod time.
+func (j *jobRun) applyDirTimes() {
+	for _, task := range j.dirs {
+		err := setFileTimes(j.targetDirPath(task.relPath), task.info)
+		if err != nil {
+			j.logError(fmt.Sprintf("Error setting directory times: %v", err))
+			j.countError()
+		}
+	}
+
+	for relPath := range j.newDirs {
+		// Route folders have no source directory to take times from
+		sourceDir, _ := j.findSource(relPath)
+		info, err := os.Stat(sourceDir)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		err = setFileTimes(j.targetDirPath(relPath), info)
+		if err != nil {
+			j.logError(fmt.Sprintf("Error setting directory times: %v", err))
+			j.countError()
+		}
+	}
+}
+
+// walkConcurrency bounds how many directories are read at once during a walk
+const walkConcurrency = 8
+
+// walkSource walks the job's source directory and calls visit for every file
+// and subdirectory that passes the filters. Subdirectories are read by up to
+// walkConcurrency goroutines, so visit may be called concurrently and in no
+// particular order. Skipped entries and errors are only logged and counted
+// when record is set, so a pre-scan leaves the run totals alone.
+func walkSource(ctx context.Context, job *jobRun, record bool, visit func(syncTask)) {
+	// With file_list only the named files are looked at
+	if config.FileList != "" {
+		visitFileList(ctx, job, record, visit)
+		return
+	}
+
+	logSkip := func(message string) {
+		if record {
+			job.logDebug(message)
+		}
+	}
+	logAccessError := func(path string, err error) {
+		if record {
+			job.logError(fmt.Sprintf("Error accessing %s: %v", path, err))
+			job.countError()
+		}
+	}
+
+	var pending sync.WaitGroup
+	slots := make(chan struct{}, walkConcurrency)
+
+	// ancestors holds the resolved path of every directory above the one being
+	// read, so a followed link that leads back into one of them is caught
+	var walkDir func(dir, relDir string, ancestors []string)
+	walkDir = func(dir, relDir string, ancestors []string) {
+		// Log unreadable directories and keep going rather than abort the whole sync
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			logAccessError(dir, err)
+			return
+		}
+		var files []syncTask
+
+		for _, entry := range entries {
+			if ctx.Err() != nil {
+				return
+			}
+
+			path := filepath.Join(dir, entry.Name())
+			relPath := filepath.Join(relDir, entry.Name())
+			info, err := entry.Info()
+			if err != nil {
+				logAccessError(path, err)
+				continue
+			}
+
+			// Links are followed, recreated in the target or skipped
+			realPath := ""
+			if info.Mode()&os.ModeSymlink != 0 {
+				if !config.FollowLinks {
+					if config.RecreateLinks {
+						visit(syncTask{job: job, path: path, relPath: relPath, info: info})
+					} else {
+						logSkip(fmt.Sprintf("Skipped link: %s", relPath))
+					}
+					continue
+				}
+
+				info, err = os.Stat(path)
+				if err == nil {
+					realPath, err = filepath.EvalSymlinks(path)
+				}
+				if err != nil {
+					logAccessError(path, err)
+					continue
+				}
+				if info.IsDir() && loopsBack(realPath, ancestors) {
+					if record {
+						job.logWarn(fmt.Sprintf("Skipped link that loops back to %s: %s", realPath, relPath))
+					}
+					continue
+				}
+				logSkip(fmt.Sprintf("Following link: %s -> %s", relPath, realPath))
+			}
+
+			// Descend into directories, pruning any that are excluded outright
+			if info.IsDir() {
+				if reason := job.dirSkipReason(relPath); reason != "" {
+					logSkip(fmt.Sprintf("Skipped %s: %s", reason, relPath))
+					continue
+				}
+				visit(syncTask{job: job, path: path, relPath: relPath, info: info})
+
+				var below []string
+				if config.FollowLinks {
+					if realPath == "" {
+						realPath = filepath.Join(ancestors[len(ancestors)-1], entry.Name())
+					}
+					below = append(append([]string(nil), ancestors...), realPath)
+				}
+
+				// Hand the subdirectory to a new goroutine while there is a free
+				// slot, otherwise read it on this one
+				select {
+				case slots <- struct{}{}:
+					pending.Add(1)
+					go func() {
+						defer pending.Done()
+						walkDir(path, relPath, below)
+						<-slots
+					}()
+				default:
+					walkDir(path, relPath, below)
+				}
+				continue
+			}
+
+			if record {
+				for _, target := range job.group() {
+					target.stats.scanned.Add(1)
+				}
+			}
+			if reason := job.fileSkipReason(relPath, info); reason != "" {
+				logSkip(fmt.Sprintf("Skipped by %s: %s", reason, relPath))
+				if record {
+					for _, target := range job.group() {
+						target.stats.skipped.Add(1)
+					}
+				}
+				continue
+			}
+
+			// With newest_per_dir the files wait until the whole directory is read
+			task := syncTask{job: job, path: path, relPath: relPath, info: info}
+			if config.NewestPerDir > 0 {
+				files = append(files, task)
+				continue
+			}
+			visit(task)
+		}
+
+		// Only the most recently modified files of the directory are synced
+		sort.Slice(files, func(a, b int) bool { return files[a].info.ModTime().After(files[b].info.ModTime()) })
+		for i, task := range files {
+			if i >= config.NewestPerDir {
+				// Logged above debug level so the rotation can be checked
+				if record {
+					job.logInfo(fmt.Sprintf("Skipped by newest_per_dir: %s", task.relPath))
+					for _, target := range job.group() {
+						target.stats.skipped.Add(1)
+					}
+				}
+				continue
+			}
+			visit(task)
+		}
+	}
+
+	root := toLongPath(job.SourceDir)
+	var ancestors []string
+	if config.FollowLinks {
+		realRoot, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			realRoot = root
+		}
+		ancestors = []string{realRoot}
+	}
+	walkDir(root, "", ancestors)
+	pending.Wait()
+}
+
+// loopsBack reports whether a link resolving to realPath points at one of
+// ancestors or above it, which would make following it walk forever.
+func loopsBack(realPath string, ancestors []string) bool {
+	for _, dir := range ancestors {
+		if isWithin(realPath, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// dirSkipReason returns why the walk should not descend into the directory at
+// relPath, or "" to enter it.
+func (j *jobRun) dirSkipReason(relPath string) string {
+	for _, target := range j.group() {
+		if target.targetInSource != "" && relPath == target.targetInSource {
+			return "target directory nested in source"
+		}
+	}
+	if isExcluded(relPath) || j.isIgnored(relPath) {
+		return "excluded directory"
+	}
+	if !inSubdirs(relPath) {
+		return "not in subdirs"
+	}
+	// A directory directly under the source is at depth 1, so max_depth 0 keeps only top-level files
+	if config.MaxDepth != nil && len(strings.Split(relPath, string(filepath.Separator))) > *config.MaxDepth {
+		return "directory beyond max_depth"
+	}
+	return ""
+}
+
+// inSubdirs reports whether the directory at relPath may be walked under
+// subdirs, which lists the only top-level directories to sync. Deeper
+// directories are decided by their top-level ancestor, and names compare
+// without case on Windows.
+func inSubdirs(relPath string) bool {
+	if len(config.Subdirs) == 0 || relPath == "." || strings.Contains(relPath, string(filepath.Separator)) {
+		return true
+	}
+	for _, name := range config.Subdirs {
+		if name == relPath || (runtime.GOOS == "windows" && strings.EqualFold(name, relPath)) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileSkipReason returns why the file at relPath should not be synced, or ""
+// to sync it.
+func (j *jobRun) fileSkipReason(relPath string, info os.FileInfo) string {
+	// Never sync partial copies left behind by an interrupted run
+	if strings.HasSuffix(relPath, tempFileSuffix) {
+		return "temporary file"
+	}
+	if !matchesFilters(relPath) || j.isIgnored(relPath) {
+		return "filter"
+	}
+	// Opening a cloud placeholder would download it, or fail when offline
+	if config.Dehydrated == dehydratedSkip && isDehydrated(info) {
+		return "dehydrated placeholder"
+	}
+	if name := excludedAttribute(info); name != "" {
+		return "exclude_attributes (" + name + ")"
+	}
+	if config.MinSizeBytes > 0 && info.Size() < config.MinSizeBytes {
+		return "min_size_bytes"
+	}
+	if config.MaxSizeBytes > 0 && info.Size() > config.MaxSizeBytes {
+		return "max_size_bytes"
+	}
+	if config.ModifiedWithinHours > 0 && info.ModTime().Before(time.Now().Add(-time.Duration(config.ModifiedWithinHours*float64(time.Hour)))) {
+		return "modified_within_hours"
+	}
+	if !config.ModifiedAfter.IsZero() && info.ModTime().Before(config.ModifiedAfter) {
+		return "modified_after"
+	}
+	if !config.ModifiedBefore.IsZero() && !info.ModTime().Before(config.ModifiedBefore) {
+		return "modified_before"
+	}
+	return ""
+}
+
+// Attribute names accepted by exclude_attributes
+const (
+	attributeHidden    = "hidden"
+	attributeSystem    = "system"
+	attributeTemporary = "temporary"
+	attributeReadOnly  = "readonly"
+)
+
+// backupPath returns where the current target of relPath is kept before being
+// overwritten, with a timestamp added to the file name, or "" when backups are
+// off. A named job, such as each of target_dirs, keeps its backups in a folder
+// of its own so jobs backing up the same file never share a path.
+func (j *jobRun) backupPath(relPath string) string {
+	if config.BackupDir == "" {
+		return ""
+	}
+	relPath = compressedName(normalizePath(relPath))
+	ext := filepath.Ext(relPath)
+	stamp := time.Now().Format("20060102-150405")
+	return filepath.Join(config.BackupDir, j.backupFolder(), strings.TrimSuffix(relPath, ext)+"."+stamp+ext)
+}
+
+// backupFolder returns the job's name as a single folder name, turning the
+// separators and drive colon of a target_dirs path into underscores.
+func (j *jobRun) backupFolder() string {
+	folder := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' {
+			return '_'
+		}
+		return r
+	}, j.Name)
+	return strings.Trim(folder, "_")
+}
+
+// targetPath returns where the file at relPath under the source lands in the target.
+// Files whose extension has a route land under that subfolder of the target instead.
+func (j *jobRun) targetPath(relPath string) string {
+	name := compressedName(normalizePath(relPath))
+	if route := routeFor(relPath); route != "" {
+		return filepath.Join(j.TargetDir, route, name)
+	}
+	return filepath.Join(j.TargetDir, name)
+}
+
+// targetDirPath returns where the directory at relPath under the source lands
+// in the target. Directories are never routed.
+func (j *jobRun) targetDirPath(relPath string) string {
+	return filepath.Join(j.TargetDir, normalizePath(relPath))
+}
+
+// sourceRelPath maps a file's path relative to the target back to its path
+// relative to the source, undoing compression and any route.
+func (j *jobRun) sourceRelPath(targetRel string) string {
+	if config.Compress {
+		targetRel = strings.TrimSuffix(targetRel, gzipSuffix)
+	}
+	return unroute(targetRel)
+}
+
+// hasSource reports whether the file at targetRel under the target still has
+// a source counterpart.
+func (j *jobRun) hasSource(targetRel string) bool {
+	if _, ok := j.findSource(j.sourceRelPath(targetRel)); ok {
+		return true
+	}
+	// A source that was already gzipped keeps its name in the target
+	if config.Compress && strings.HasSuffix(targetRel, gzipSuffix) {
+		_, ok := j.findSource(unroute(targetRel))
+		return ok
+	}
+	return false
+}
+
+// unroute strips the route folder from a target path whose extension has one.
+func unroute(targetRel string) string {
+	route := routeFor(targetRel)
+	if route == "" {
+		return targetRel
+	}
+	prefix := filepath.Clean(route) + string(filepath.Separator)
+	return strings.TrimPrefix(targetRel, prefix)
+}
+
+// routeFor returns the target subfolder configured in routes for the file's
+// extension, or "" when it has none. Extensions match with or without the
+// leading dot and in any case.
+func routeFor(relPath string) string {
+	if len(config.Routes) == 0 {
+		return ""
+	}
+	ext := strings.ToLower(filepath.Ext(relPath))
+	if ext == "" {
+		return ""
+	}
+	for key, route := range config.Routes {
+		key = strings.ToLower(key)
+		if key == ext || "."+key == ext {
+			return route
+		}
+	}
+	return ""
+}
+
+// syncFile copies a single source file to its place under the job's target when needed.
+func syncFile(ctx context.Context, task syncTask, dryRun bool) {
+	syncFiles(ctx, []syncTask{task}, dryRun)
+}
+
+// forGroup returns a copy of the task for every job in its job's group.
+func (t syncTask) forGroup() []syncTask {
+	group := t.job.group()
+	tasks := make([]syncTask, len(group))
+	for i, target := range group {
+		tasks[i] = t
+		tasks[i].job = target
+	}
+	return tasks
+}
+
+// copyPlan is a target that needs the source's content copied to it
+type copyPlan struct {
+	task       syncTask
+	targetPath string
+	content    string
+	// linkID is set on the first copy of a hard-linked source, which
+	// finishLink is told about once the copy is done, and claimedContent
+	// likewise for the first copy of content for finishContent
+	linkID         *fileID
+	claimedContent bool
+	throughput     string
+}
+
+// placement is what planCopy left a target with when it needed no copy
+type placement int
+
+const (
+	// targetMissing is a target that did not get the file, e.g. a dry run
+	targetMissing placement = iota
+	// targetHeld is a target that already had the file
+	targetHeld
+	// targetLinked is a target the file was just linked into
+	targetLinked
+)
+
+// syncFiles copies the source file shared by the tasks to the target of each
+// one that needs it, reading the source only once however many targets that
+// is. With move the source is only removed once every target has the file.
+func syncFiles(ctx context.Context, tasks []syncTask, dryRun bool) {
+	defer func() {
+		for _, task := range tasks {
+			task.job.stats.processed.Add(1)
+		}
+	}()
+
+	var plans []*copyPlan
+	held, linked := 0, 0
+	for _, task := range tasks {
+		plan, placed := task.job.planCopy(ctx, task, dryRun)
+		switch {
+		case plan != nil:
+			plans = append(plans, plan)
+		case placed == targetHeld:
+			held++
+		case placed == targetLinked:
+			linked++
+		}
+	}
+
+	var copied []*copyPlan
+	if len(plans) > 0 {
+		started := time.Now()
+		results := copyPlans(ctx, plans)
+		elapsed := time.Since(started)
+		for i, plan := range plans {
+			if plan.task.job.finishCopy(plan, results[i], elapsed) {
+				copied = append(copied, plan)
+			}
+		}
+	}
+	// A file that was only linked counts as placed just like a copy
+	if len(copied)+linked == 0 {
+		return
+	}
+
+	// The source is only marked or removed once every target holds the file
+	job, path, size := tasks[0].job, tasks[0].path, tasks[0].info.Size()
+	complete := len(copied)+linked+held == len(tasks)
+	if !complete || !config.Move {
+		if complete && config.ArchiveBitMode {
+			// Mark the source as backed up, unless it is about to be removed anyway
+			err := clearArchiveBit(path)
+			if err != nil {
+				job.logWarn(fmt.Sprintf("Could not clear the archive bit on %s: %v", path, err))
+			}
+		}
+		for _, plan := range copied {
+			plan.task.job.logInfo(fmt.Sprintf("Copied: %s%s", filepath.Base(path), plan.throughput))
+			emitEvent(eventCopy, path, size, nil)
+		}
+		return
+	}
+
+	// Only a file that was just placed successfully is removed from the source
+	var err error
+	if config.RecycleOnDelete {
+		err = recycleFile(path)
+	} else {
+		err = removeFile(path)
+	}
+	if err != nil {
+		job.logError(fmt.Sprintf("Copied but could not remove source %s: %v", path, err))
+		job.countError()
+		emitEvent(eventError, path, size, err)
+		return
+	}
+	for _, plan := range copied {
+		plan.task.job.logInfo(fmt.Sprintf("Moved: %s%s", filepath.Base(path), plan.throughput))
+		emitEvent(eventMove, path, size, nil)
+	}
+}
+
+// planCopy does everything for the task short of copying its content: it
+// skips a target already up to date, copies back to the source, reports a
+// dry run or links the target to a copy it already has. It returns a plan
+// when the content still has to be copied, and otherwise what the target was
+// left with.
+func (j *jobRun) planCopy(ctx context.Context, task syncTask, dryRun bool) (*copyPlan, placement) {
+	path, info := task.path, task.info
+
+	// Construct the target path, moving aside one that differs only in case
+	targetPath, ok := j.resolveCollision(task.relPath, j.targetPath(task.relPath))
+	if !ok {
+		j.stats.skipped.Add(1)
+		emitEvent(eventSkip, path, 0, nil)
+		return nil, targetMissing
+	}
+
+	if j.copiedBefore(task.relPath, info, targetPath) {
+		j.stats.skipped.Add(1)
+		emitEvent(eventSkip, path, 0, nil)
+		return nil, targetHeld
+	}
+
+	// Check if the file needs to be copied, and which way
+	direction, targetInfo := copyDirectionFor(task, targetPath)
+	if direction == copyNone {
+		j.stats.skipped.Add(1)
+		emitEvent(eventSkip, path, 0, nil)
+		return nil, targetHeld
+	}
+	if direction == copyToSource {
+		j.copyBackToSource(ctx, task, targetPath, targetInfo, dryRun)
+		return nil, targetMissing
+	}
+
+	if dryRun {
+		if config.Move {
+			j.logInfo(fmt.Sprintf("Would move: %s", path))
+		} else {
+			j.logInfo(fmt.Sprintf("Would copy: %s", path))
+		}
+		wouldCopyFiles.Add(1)
+		wouldCopyBytes.Add(info.Size())
+		emitEvent(eventWouldCopy, path, info.Size(), nil)
+		if checkAccess {
+			j.checkFileAccess(path, targetPath)
+		}
+		return nil, targetMissing
+	}
+
+	// A file hard linked to one already copied becomes a link to that copy
+	plan := &copyPlan{task: task, targetPath: targetPath}
+	if id, ok := hardLinkID(path, info); ok {
+		origin, first := j.claimLink(id, targetPath)
+		if first {
+			plan.linkID = &id
+		} else if origin != "" {
+			err := linkFile(origin, targetPath)
+			if err == nil {
+				j.stats.copied.Add(1)
+				j.logInfo(fmt.Sprintf("Linked: %s", filepath.Base(path)))
+				emitEvent(eventLink, path, 0, nil)
+				return nil, targetLinked
+			}
+			j.logDebug(fmt.Sprintf("Could not link %s, copying instead: %v", path, err))
+		}
+	}
+	// Once the link is claimed, every way out without a copy must release it
+	abandon := func(placed placement) (*copyPlan, placement) {
+		if plan.linkID != nil {
+			j.finishLink(*plan.linkID, false)
+		}
+		return nil, placed
+	}
+
+	if config.PreserveDirTimes {
+		err := j.makeTargetDirs(filepath.Dir(targetPath))
+		if err != nil {
+			j.logError(fmt.Sprintf("Error creating directory: %v", err))
+			j.countError()
+			emitEvent(eventError, path, 0, err)
+			return abandon(targetMissing)
+		}
+	}
+
+	// Content already in the target becomes a link instead of a second copy
+	if config.Dedupe {
+		plan.content = j.contentDigest(task)
+		origin, first := j.claimContent(plan.content, targetPath)
+		plan.claimedContent = first
+		if origin != "" {
+			err := linkFile(origin, targetPath)
+			if err == nil {
+				j.stats.copied.Add(1)
+				j.logInfo(fmt.Sprintf("Linked duplicate: %s", filepath.Base(path)))
+				emitEvent(eventLink, path, 0, nil)
+				return abandon(targetLinked)
+			}
+			j.logDebug(fmt.Sprintf("Could not link %s to its duplicate, copying instead: %v", path, err))
+		}
+	}
+	return plan, targetMissing
+}
+
+// copyPlans copies the source shared by the plans to each of their targets,
+// waiting for a target that went offline to come back and copying to it again.
+// A target given up on while offline gets errTargetOffline, which finishCopy
+// passes over since the run is being aborted anyway.
+func copyPlans(ctx context.Context, plans []*copyPlan) []copyResult {
+	source := plans[0].task
+	dests := make([]copyDest, len(plans))
+	for i, plan := range plans {
+		dests[i] = copyDest{job: plan.task.job, targetPath: plan.targetPath, backupPath: plan.task.job.backupPath(source.relPath)}
+	}
+	results := copyAllWithRetry(ctx, source.path, dests, source.info)
+
+	var again []int
+	for i, result := range results {
+		if result.err != nil && dests[i].job.targetGone(result.err) {
+			if !dests[i].job.waitForTarget(ctx) {
+				results[i] = copyResult{err: errTargetOffline}
+				continue
+			}
+			again = append(again, i)
+		}
+	}
+	if len(again) > 0 {
+		retry := make([]copyDest, len(again))
+		for k, i := range again {
+			retry[k] = dests[i]
+		}
+		for k, result := range copyAllWithRetry(ctx, source.path, retry, source.info) {
+			results[again[k]] = result
+		}
+	}
+	return results
+}
+
+// finishCopy records how the copy of a plan went and reports whether the
+// target now holds the file.
+func (j *jobRun) finishCopy(plan *copyPlan, result copyResult, elapsed time.Duration) bool {
+	task, err := plan.task, result.err
+	if plan.linkID != nil {
+		defer func() { j.finishLink(*plan.linkID, err == nil) }()
+	}
+	if plan.claimedContent {
+		defer func() { j.finishContent(plan.content, err == nil) }()
+	}
+	if errors.Is(err, errTargetOffline) {
+		return false
+	}
+	if err != nil {
+		if isLockedError(err) && j.deferLocked(task) {
+			j.logWarn(fmt.Sprintf("File in use, will retry after the main pass: %s", task.path))
+			return false
+		}
+		j.logError(fmt.Sprintf("Error copying file: %v", err))
+		j.countError()
+		emitEvent(eventError, task.path, 0, err)
+		return false
+	}
+
+	if config.LogThroughput {
+		plan.throughput = " " + formatThroughput(task.info.Size(), elapsed)
+	}
+	j.stats.copied.Add(1)
+	j.stats.bytesCopied.Add(task.info.Size())
+	// The new copy can have the old one's size and mod time but not its digest
+	j.targetSums.Delete(plan.targetPath)
+	j.recordManifest(task, plan.targetPath, result.digest)
+	j.recordState(task)
+	return true
+}
+
+// deferLocked queues a task whose file was in use for retryLocked and reports
+// true, or records it as still locked and reports false once the retry has run.
+func (j *jobRun) deferLocked(task syncTask) bool {
+	j.lockedMu.Lock()
+	defer j.lockedMu.Unlock()
+	if !j.lockedRetried {
+		j.locked = append(j.locked, task)
+		return true
+	}
+	j.stillLocked = append(j.stillLocked, task.relPath)
+	return false
+}
+
+// retryLocked makes one more attempt at every file deferLocked queued.
+func (j *jobRun) retryLocked(ctx context.Context, dryRun bool) {
+	j.lockedMu.Lock()
+	tasks := j.locked
+	j.locked = nil
+	j.lockedRetried = true
+	j.lockedMu.Unlock()
+
+	for _, task := range tasks {
+		if ctx.Err() != nil {
+			return
+		}
+		syncFile(ctx, task, dryRun)
+	}
+}
+
+// lockedFiles returns the files that were still in use after retryLocked.
+func (j *jobRun) lockedFiles() []string {
+	j.lockedMu.Lock()
+	defer j.lockedMu.Unlock()
+	return append([]string(nil), j.stillLocked...)
+}
+
+// mirrorTarget deletes files under the job's target that have no counterpart
+// in its source and returns how many were removed. In a dry run the files are
+// only reported.
+func mirrorTarget(job *jobRun, dryRun bool) int {
+	removed := 0
+	err := job.walkOrphans(func(path, relPath string, info os.FileInfo) {
+		if dryRun {
+			job.logInfo(fmt.Sprintf("Would delete: %s", relPath))
+			emitEvent(eventWouldDelete, path, info.Size(), nil)
+			removed++
+			return
+		}
+
+		var err error
+		if config.RecycleOnDelete {
+			err = recycleFile(path)
+		} else {
+			err = os.Remove(path)
+		}
+		if err != nil {
+			job.logError(fmt.Sprintf("Error deleting file: %v", err))
+			emitEvent(eventError, path, 0, err)
+			return
+		}
+		job.logInfo(fmt.Sprintf("Deleted: %s", relPath))
+		emitEvent(eventDelete, path, info.Size(), nil)
+		removed++
+	})
+
+	if err != nil {
+		job.logError(fmt.Sprintf("Error walking the target path: %v", err))
+	}
+	return removed
+}
+
+// walkOrphans calls visit for every file in the job's target that passes the
+// sync filters but no longer has a source, with its path relative to the target.
+func (j *jobRun) walkOrphans(visit func(path, relPath string, info os.FileInfo)) error {
+	root := toLongPath(j.TargetDir)
+
+	// Nothing is orphaned in a target the sync has not created yet
+	if !fileExists(root) {
+		return nil
+	}
+
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			j.logError(fmt.Sprintf("Error getting relative path: %v", err))
+			return nil
+		}
+
+		// Skip directories, never descending into a source nested in the target
+		// or one outside subdirs
+		if info.IsDir() {
+			if j.sourceInTarget != "" && relPath == j.sourceInTarget {
+				return filepath.SkipDir
+			}
+			if !inSubdirs(relPath) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Files outside the sync filters are never touched
+		sourceRel := j.sourceRelPath(relPath)
+		if !matchesFilters(sourceRel) || j.isIgnored(sourceRel) {
+			return nil
+		}
+		if j.hasSource(relPath) || j.isRenamedCollision(relPath) {
+			return nil
+		}
+
+		visit(path, relPath, info)
+		return nil
+	})
+}
+
+// pruneTargetDirs removes the directories mirror has left empty under the
+// job's target, keeping any that still exist in the source. It returns how
+// many were removed.
+func pruneTargetDirs(job *jobRun) int {
+	return removeEmptyDirs(job.TargetDir, func(relPath string) bool {
+		if job.sourceInTarget != "" && isWithin(job.sourceInTarget, relPath) {
+			return true
+		}
+		_, ok := job.findSource(relPath)
+		return ok
+	}, func(dir string) {
+		job.logInfo(fmt.Sprintf("Removed empty target directory: %s", dir))
+	})
+}
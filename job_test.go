@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeInfo is the os.FileInfo of a file that only exists in a test
+type fakeInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (f fakeInfo) Name() string       { return f.name }
+func (f fakeInfo) Size() int64        { return f.size }
+func (f fakeInfo) Mode() os.FileMode  { return 0644 }
+func (f fakeInfo) ModTime() time.Time { return f.modTime }
+func (f fakeInfo) IsDir() bool        { return false }
+func (f fakeInfo) Sys() any           { return nil }
+
+// newTestJob returns a job syncing between fresh source and target
+// directories holding the given files, each containing its relative path.
+func newTestJob(t *testing.T, source, target []string) *jobRun {
+	t.Helper()
+	dir := t.TempDir()
+	job := &jobRun{Job: Job{SourceDir: filepath.Join(dir, "source"), TargetDir: filepath.Join(dir, "target")}}
+	for _, path := range []string{job.SourceDir, job.TargetDir} {
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, relPath := range source {
+		writeFile(t, filepath.Join(job.SourceDir, relPath), relPath, time.Now())
+	}
+	for _, relPath := range target {
+		writeFile(t, filepath.Join(job.TargetDir, relPath), relPath, time.Now())
+	}
+	return job
+}
+
+func TestFileSkipReason(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		config  Config
+		ignore  []string
+		relPath string
+		size    int64
+		age     time.Duration
+		want    string
+	}{
+		{name: "plain file", relPath: "a.txt", want: ""},
+		{name: "temporary file", relPath: "a.txt" + tempFileSuffix, want: "temporary file"},
+		{name: "exclude glob", config: Config{Exclude: []string{"*.log"}}, relPath: filepath.Join("logs", "a.log"), want: "filter"},
+		{name: "exclude path", config: Config{Exclude: []string{filepath.Join("logs", "*.txt")}}, relPath: filepath.Join("logs", "a.txt"), want: "filter"},
+		{name: "not included", config: Config{Include: []string{"*.doc"}}, relPath: "a.txt", want: "filter"},
+		{name: "included", config: Config{Include: []string{"*.doc"}}, relPath: filepath.Join("docs", "a.doc"), want: ""},
+		{name: "gosyncignore", ignore: []string{"*.bak"}, relPath: filepath.Join("docs", "a.bak"), want: "filter"},
+		{name: "too small", config: Config{MinSizeBytes: 10}, relPath: "a.txt", size: 5, want: "min_size_bytes"},
+		{name: "at min size", config: Config{MinSizeBytes: 10}, relPath: "a.txt", size: 10, want: ""},
+		{name: "too large", config: Config{MaxSizeBytes: 10}, relPath: "a.txt", size: 50, want: "max_size_bytes"},
+		{name: "at max size", config: Config{MaxSizeBytes: 10}, relPath: "a.txt", size: 10, want: ""},
+		{name: "too old", config: Config{ModifiedWithinHours: 1}, relPath: "a.txt", age: -2 * time.Hour, want: "modified_within_hours"},
+		{name: "recent enough", config: Config{ModifiedWithinHours: 1}, relPath: "a.txt", age: -30 * time.Minute, want: ""},
+		{name: "before modified_after", config: Config{ModifiedAfter: now.Add(-time.Hour)}, relPath: "a.txt", age: -2 * time.Hour, want: "modified_after"},
+		{name: "not before modified_before", config: Config{ModifiedBefore: now.Add(-time.Hour)}, relPath: "a.txt", want: "modified_before"},
+		{name: "inside the window", config: Config{ModifiedAfter: now.Add(-2 * time.Hour), ModifiedBefore: now.Add(-time.Hour)}, relPath: "a.txt", age: -90 * time.Minute, want: ""},
+		{name: "exclude regex", config: Config{ExcludeRegex: []string{`^cache`}}, relPath: "cache.db", want: "filter"},
+		{name: "exclude regex elsewhere", config: Config{ExcludeRegex: []string{`^cache`}}, relPath: "mycache.db", want: ""},
+		{name: "include regex", config: Config{IncludeRegex: []string{`\.docx?$`}}, relPath: "a.doc", want: ""},
+		{name: "not in include regex", config: Config{IncludeRegex: []string{`\.docx?$`}}, relPath: "a.txt", want: "filter"},
+		{name: "include glob or regex", config: Config{Include: []string{"*.txt"}, IncludeRegex: []string{`\.docx?$`}}, relPath: "a.txt", want: ""},
+		{name: "regex matches forward slashes", config: Config{ExcludeRegex: []string{`^build/`}}, relPath: filepath.Join("build", "a.o"), want: "filter"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, test.config)
+			if err := compileRegexFilters(); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { excludeRegex, includeRegex = nil, nil })
+
+			job := &jobRun{ignore: test.ignore}
+			info := fakeInfo{name: filepath.Base(test.relPath), size: test.size, modTime: now.Add(test.age)}
+			if got := job.fileSkipReason(test.relPath, info); got != test.want {
+				t.Errorf("fileSkipReason(%q) = %q, want %q", test.relPath, got, test.want)
+			}
+		})
+	}
+}
+
+func TestDirSkipReason(t *testing.T) {
+	depth := 1
+	tests := []struct {
+		name     string
+		config   Config
+		ignore   []string
+		inSource string
+		relPath  string
+		want     string
+	}{
+		{name: "plain directory", relPath: "docs", want: ""},
+		{name: "target nested in source", inSource: "backup", relPath: "backup", want: "target directory nested in source"},
+		{name: "beside the nested target", inSource: "backup", relPath: "backups", want: ""},
+		{name: "excluded", config: Config{Exclude: []string{"node_modules"}}, relPath: filepath.Join("app", "node_modules"), want: "excluded directory"},
+		{name: "gosyncignore", ignore: []string{"tmp"}, relPath: "tmp", want: "excluded directory"},
+		{name: "beyond max_depth", config: Config{MaxDepth: &depth}, relPath: filepath.Join("a", "b"), want: "directory beyond max_depth"},
+		{name: "within max_depth", config: Config{MaxDepth: &depth}, relPath: "a", want: ""},
+		{name: "not in subdirs", config: Config{Subdirs: []string{"docs"}}, relPath: "music", want: "not in subdirs"},
+		{name: "listed subdir", config: Config{Subdirs: []string{"docs"}}, relPath: "docs", want: ""},
+		{name: "below listed subdir", config: Config{Subdirs: []string{"docs"}}, relPath: filepath.Join("docs", "old"), want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, test.config)
+			job := &jobRun{ignore: test.ignore, targetInSource: test.inSource}
+			if got := job.dirSkipReason(test.relPath); got != test.want {
+				t.Errorf("dirSkipReason(%q) = %q, want %q", test.relPath, got, test.want)
+			}
+		})
+	}
+}
+
+func TestTargetPath(t *testing.T) {
+	targetDir := filepath.Join("backup", "target")
+	routes := map[string]string{"jpg": "Pictures", ".PDF": "Documents"}
+	tests := []struct {
+		name    string
+		config  Config
+		relPath string
+		want    string
+	}{
+		{name: "unrouted", relPath: filepath.Join("a", "b.txt"), want: filepath.Join(targetDir, "a", "b.txt")},
+		{name: "routed by extension", config: Config{Routes: routes}, relPath: filepath.Join("a", "b.jpg"), want: filepath.Join(targetDir, "Pictures", "a", "b.jpg")},
+		{name: "route ignores case", config: Config{Routes: routes}, relPath: "c.Pdf", want: filepath.Join(targetDir, "Documents", "c.Pdf")},
+		{name: "no extension", config: Config{Routes: routes}, relPath: "jpg", want: filepath.Join(targetDir, "jpg")},
+		{name: "other extension", config: Config{Routes: routes}, relPath: "d.png", want: filepath.Join(targetDir, "d.png")},
+		{name: "compressed", config: Config{Compress: true}, relPath: "e.txt", want: filepath.Join(targetDir, "e.txt.gz")},
+		{name: "already gzipped", config: Config{Compress: true}, relPath: "f.tar.gz", want: filepath.Join(targetDir, "f.tar.gz")},
+		{name: "routed and compressed", config: Config{Routes: routes, Compress: true}, relPath: "g.jpg", want: filepath.Join(targetDir, "Pictures", "g.jpg.gz")},
+		{name: "normalized to nfc", config: Config{NormalizeUnicode: normalizeNFC}, relPath: "cafe\u0301.txt", want: filepath.Join(targetDir, "caf\u00e9.txt")},
+		{name: "normalized to nfd", config: Config{NormalizeUnicode: normalizeNFD}, relPath: "caf\u00e9.txt", want: filepath.Join(targetDir, "cafe\u0301.txt")},
+		{name: "left as it is", relPath: "cafe\u0301.txt", want: filepath.Join(targetDir, "cafe\u0301.txt")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, test.config)
+			job := &jobRun{Job: Job{TargetDir: targetDir}}
+			if got := job.targetPath(test.relPath); got != test.want {
+				t.Errorf("targetPath(%q) = %q, want %q", test.relPath, got, test.want)
+			}
+		})
+	}
+}
+
+func TestWalkOrphans(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         Config
+		source, target []string
+		want           []string
+	}{
+		{name: "nothing orphaned", source: []string{"a.txt"}, target: []string{"a.txt"}},
+		{name: "orphans at any depth", source: []string{"a.txt"}, target: []string{"a.txt", "b.txt", filepath.Join("sub", "c.txt")}, want: []string{"b.txt", filepath.Join("sub", "c.txt")}},
+		{name: "filtered files left alone", config: Config{Exclude: []string{"*.log"}}, target: []string{"a.log", "b.txt"}, want: []string{"b.txt"}},
+		{name: "routed copy kept", config: Config{Routes: map[string]string{"jpg": "Pictures"}}, source: []string{"a.jpg"}, target: []string{filepath.Join("Pictures", "a.jpg"), filepath.Join("Pictures", "b.jpg")}, want: []string{filepath.Join("Pictures", "b.jpg")}},
+		{name: "outside subdirs left alone", config: Config{Subdirs: []string{"docs"}}, target: []string{filepath.Join("docs", "a.txt"), filepath.Join("music", "b.mp3")}, want: []string{filepath.Join("docs", "a.txt")}},
+		{name: "normalized copy kept", config: Config{NormalizeUnicode: normalizeNFC}, source: []string{filepath.Join("cafe\u0301", "a.txt")}, target: []string{filepath.Join("caf\u00e9", "a.txt"), filepath.Join("caf\u00e9", "b.txt")}, want: []string{filepath.Join("caf\u00e9", "b.txt")}},
+		{name: "compressed copies kept", config: Config{Compress: true}, source: []string{"a.txt", "b.tar.gz"}, target: []string{"a.txt.gz", "b.tar.gz", "c.txt.gz"}, want: []string{"c.txt.gz"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, test.config)
+			job := newTestJob(t, test.source, test.target)
+
+			var got []string
+			err := job.walkOrphans(func(path, relPath string, info os.FileInfo) {
+				got = append(got, relPath)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("walkOrphans() visited %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWalkOrphansMissingTarget(t *testing.T) {
+	useConfig(t, Config{})
+	job := &jobRun{Job: Job{SourceDir: t.TempDir(), TargetDir: filepath.Join(t.TempDir(), "missing")}}
+	err := job.walkOrphans(func(path, relPath string, info os.FileInfo) {
+		t.Errorf("walkOrphans() visited %q in a missing target", relPath)
+	})
+	if err != nil {
+		t.Errorf("walkOrphans() = %v, want nil", err)
+	}
+}
+
+// syncTree syncs every file of the job's source, in name order, after
+// reading their info up front the way the walk does.
+func syncTree(t *testing.T, job *jobRun) {
+	t.Helper()
+	entries, err := os.ReadDir(job.SourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tasks []syncTask
+	for _, entry := range entries {
+		path := filepath.Join(job.SourceDir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tasks = append(tasks, syncTask{job: job, path: path, relPath: entry.Name(), info: info})
+	}
+	for _, task := range tasks {
+		syncFile(context.Background(), task, false)
+	}
+}
+
+func TestSyncFileMove(t *testing.T) {
+	useConfig(t, Config{Move: true})
+	job := newTestJob(t, []string{"a.txt", "b.txt"}, []string{"b.txt"})
+	syncTree(t, job)
+
+	if fileExists(filepath.Join(job.SourceDir, "a.txt")) {
+		t.Error("copied source a.txt was not removed")
+	}
+	// Only a file this run placed is removed, not one the target already had
+	if !fileExists(filepath.Join(job.SourceDir, "b.txt")) {
+		t.Error("up to date source b.txt was removed")
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if !fileExists(filepath.Join(job.TargetDir, name)) {
+			t.Errorf("target %s is missing", name)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// openJobLog opens the job's log_file for appending, resolving a relative
+// path next to the main log. A job without one logs to the main log.
+func (j *jobRun) openJobLog() error {
+	if j.LogFile == "" {
+		return nil
+	}
+	path := j.LogFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(logFilePath), path)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	j.logFile = file
+	return nil
+}
+
+// closeJobLog closes the job's log_file if it has one open.
+func (j *jobRun) closeJobLog() {
+	j.logMu.Lock()
+	defer j.logMu.Unlock()
+	if j.logFile != nil {
+		j.logFile.Close()
+		j.logFile = nil
+	}
+}
+
+// log writes message to the job's own log_file under its own mutex, so busy
+// jobs do not contend for the main log, or tagged with the job name to the
+// main log when it has none. Errors still go to error_log as well.
+func (j *jobRun) log(level logLevel, message string) {
+	if j.logFile == nil {
+		logMessage(level, j.prefix()+message)
+		return
+	}
+	if level > currentLogLevel {
+		return
+	}
+
+	timestamp := time.Now().Format(time.RFC3339)
+	logEntry := fmt.Sprintf("%s - %s - %s\n", timestamp, level, message)
+	j.logMu.Lock()
+	if j.logFile != nil {
+		j.logFile.WriteString(logEntry)
+	}
+	j.logMu.Unlock()
+
+	if level == levelError {
+		mu.Lock()
+		if errorLogFile != nil {
+			errorLogFile.WriteString(fmt.Sprintf("%s - %s - %s\n", timestamp, level, j.prefix()+message))
+		}
+		mu.Unlock()
+	}
+}
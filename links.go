@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// recreateLink makes the target copy of a source link a link to the same
+// place, for recreate_links. An existing target that already points there is
+// left alone.
+func (j *jobRun) recreateLink(task syncTask, dryRun bool) {
+	linkTarget, err := os.Readlink(task.path)
+	if err != nil {
+		j.logError(fmt.Sprintf("Error reading link %s: %v", task.path, err))
+		j.countError()
+		return
+	}
+
+	targetPath := toLongPath(j.targetPath(task.relPath))
+	if existing, err := os.Readlink(targetPath); err == nil && existing == linkTarget {
+		j.stats.skipped.Add(1)
+		return
+	}
+
+	if dryRun {
+		j.logInfo(fmt.Sprintf("Would link: %s -> %s", task.relPath, linkTarget))
+		return
+	}
+
+	err = os.MkdirAll(filepath.Dir(targetPath), os.ModePerm)
+	if err == nil {
+		err = removeFile(targetPath)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if err == nil {
+		info, statErr := os.Stat(task.path)
+		err = createLink(linkTarget, j.targetPath(task.relPath), statErr == nil && info.IsDir())
+	}
+	if err != nil {
+		j.logError(fmt.Sprintf("Error recreating link %s: %v", task.relPath, err))
+		j.countError()
+		return
+	}
+	j.stats.copied.Add(1)
+	j.logInfo(fmt.Sprintf("Recreated link: %s -> %s", task.relPath, linkTarget))
+}
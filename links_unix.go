@@ -0,0 +1,10 @@
+//go:build unix
+
+package main
+
+import "os"
+
+// createLink creates a symlink at path pointing to linkTarget.
+func createLink(linkTarget, path string, isDir bool) error {
+	return os.Symlink(linkTarget, path)
+}
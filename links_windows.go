@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// createLink creates a symlink at path pointing to linkTarget. Creating
+// symlinks needs a privilege most accounts lack, so a directory link falls
+// back to a junction, which any user may create.
+func createLink(linkTarget, path string, isDir bool) error {
+	err := os.Symlink(linkTarget, path)
+	if err == nil || !isDir {
+		return err
+	}
+	if exec.Command("cmd", "/c", "mklink", "/J", path, linkTarget).Run() != nil {
+		return err
+	}
+	return nil
+}
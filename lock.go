@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// lockFileName is created next to the executable while a sync runs
+const lockFileName = "gosync.lock"
+
+// lockPath is the lock file held by this run, or "" when none is held
+var lockPath string
+
+// acquireLock creates the lock file at path holding this process's PID. It
+// fails when another live process already holds it, unless force is set. A
+// lock left behind by a process that has since exited is replaced.
+func acquireLock(path string, force bool) error {
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(file, "%d\n", os.Getpid())
+			closeErr := file.Close()
+			if err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return err
+			}
+			lockPath = path
+			return nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+
+		pid, err := lockOwner(path)
+		if err == nil && processAlive(pid) && !force {
+			return fmt.Errorf("another sync (PID %d) is already running; remove %s or use --force if it is stale", pid, path)
+		}
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("could not create %s", path)
+}
+
+// lockOwner reads the PID recorded in the lock file at path.
+func lockOwner(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// releaseLock removes the lock file taken by acquireLock.
+func releaseLock() {
+	if lockPath == "" {
+		return
+	}
+	os.Remove(lockPath)
+	lockPath = ""
+}
@@ -0,0 +1,12 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// processAlive reports whether a process with the given PID is running.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	// EPERM means the process exists but belongs to another user
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// logLevel orders log messages from most to least important
+type logLevel int
+
+const (
+	levelError logLevel = iota
+	levelWarn
+	levelInfo
+	levelDebug
+)
+
+// currentLogLevel is the most verbose level written to the log
+var currentLogLevel = levelInfo
+
+func (l logLevel) String() string {
+	switch l {
+	case levelError:
+		return "ERROR"
+	case levelWarn:
+		return "WARN"
+	case levelInfo:
+		return "INFO"
+	default:
+		return "DEBUG"
+	}
+}
+
+// parseLogLevel converts the log_level config value, defaulting to INFO when empty.
+func parseLogLevel(name string) (logLevel, error) {
+	switch strings.ToLower(name) {
+	case "error":
+		return levelError, nil
+	case "warn", "warning":
+		return levelWarn, nil
+	case "", "info":
+		return levelInfo, nil
+	case "debug":
+		return levelDebug, nil
+	}
+	return levelInfo, fmt.Errorf("unknown log_level %q, expected error, warn, info or debug", name)
+}
+
+func logError(message string) { logMessage(levelError, message) }
+func logWarn(message string)  { logMessage(levelWarn, message) }
+func logInfo(message string)  { logMessage(levelInfo, message) }
+func logDebug(message string) { logMessage(levelDebug, message) }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// logFilePath is where logFile was opened, kept so the log can be rotated
+var logFilePath string
+
+// logSize tracks the bytes in the current log file so rotation needs no stat per write
+var logSize int64
+
+// openLog opens the log at path for appending, rotating it first when it has
+// already outgrown log_max_mb.
+func openLog(path string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	logFilePath = path
+	err := reopenLog()
+	if err != nil {
+		return err
+	}
+	if logNeedsRotation() {
+		return rotateLog()
+	}
+	return nil
+}
+
+// closeLog closes the current log file, and the error log if one is open.
+func closeLog() {
+	mu.Lock()
+	defer mu.Unlock()
+	logFile.Close()
+	if errorLogFile != nil {
+		errorLogFile.Close()
+		errorLogFile = nil
+	}
+}
+
+// errorLogFile receives a copy of every ERROR message when error_log is set,
+// and is nil otherwise
+var errorLogFile *os.File
+var errorLogPath string
+
+// openErrorLog opens the error_log at path for appending, resolving a relative
+// path next to the main log. An empty path closes any error log left open by
+// an earlier cycle.
+func openErrorLog(path string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if path != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(logFilePath), path)
+	}
+	if path == errorLogPath {
+		return nil
+	}
+	if errorLogFile != nil {
+		errorLogFile.Close()
+		errorLogFile = nil
+	}
+	errorLogPath = ""
+	if path == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	errorLogFile = file
+	errorLogPath = path
+	return nil
+}
+
+// reopenLog opens logFilePath and records its size. mu must be held.
+func reopenLog() error {
+	file, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	logFile = file
+	logSize = info.Size()
+	return nil
+}
+
+// logNeedsRotation reports whether the log has grown past log_max_mb. mu must be held.
+func logNeedsRotation() bool {
+	return config.LogMaxMB > 0 && logSize > int64(config.LogMaxMB)*1024*1024
+}
+
+// rotateLog renames the log to sync.log.1, shifting older archives up and
+// dropping any beyond log_keep, then starts a fresh log. mu must be held.
+func rotateLog() error {
+	logFile.Close()
+
+	keep := config.LogKeep
+	if keep < 1 {
+		os.Remove(logFilePath)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", logFilePath, keep))
+		for i := keep - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", logFilePath, i), fmt.Sprintf("%s.%d", logFilePath, i+1))
+		}
+		err := os.Rename(logFilePath, logFilePath+".1")
+		if err != nil {
+			reopenLog()
+			return err
+		}
+	}
+
+	return reopenLog()
+}
@@ -0,0 +1,8 @@
+//go:build unix
+
+package main
+
+// toLongPath returns path unchanged; Unix has no MAX_PATH limit to work around.
+func toLongPath(path string) string {
+	return path
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// longPathPrefix lifts the 260 character MAX_PATH limit on Windows file APIs
+const longPathPrefix = `\\?\`
+
+// toLongPath returns path as an absolute \\?\ path so Windows APIs accept it
+// beyond MAX_PATH. UNC paths become \\?\UNC\server\share.
+func toLongPath(path string) string {
+	if strings.HasPrefix(path, longPathPrefix) {
+		return path
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return longPathPrefix + `UNC\` + abs[2:]
+	}
+	return longPathPrefix + abs
+}
@@ -2,137 +2,1119 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"strings"
 	"sync"
-	"syscall"
+	"sync/atomic"
 	"time"
 
-	"golang.org/x/sys/windows"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	SourceDir string `json:"source_dir"`
-	TargetDir string `json:"target_dir"`
+	SourceDir              string            `json:"source_dir" yaml:"source_dir"`
+	TargetDir              string            `json:"target_dir" yaml:"target_dir"`
+	Jobs                   []Job             `json:"jobs" yaml:"jobs"`
+	Mirror                 bool              `json:"mirror" yaml:"mirror"`
+	Move                   bool              `json:"move" yaml:"move"`
+	RemoveEmptySourceDirs  bool              `json:"remove_empty_source_dirs" yaml:"remove_empty_source_dirs"`
+	Compare                compareSetting    `json:"compare" yaml:"compare"`
+	Include                []string          `json:"include" yaml:"include"`
+	Exclude                []string          `json:"exclude" yaml:"exclude"`
+	BufferKB               int               `json:"buffer_kb" yaml:"buffer_kb"`
+	MaxRetries             int               `json:"max_retries" yaml:"max_retries"`
+	RetryDelayMS           int               `json:"retry_delay_ms" yaml:"retry_delay_ms"`
+	FreeSpaceMarginMB      int               `json:"free_space_margin_mb" yaml:"free_space_margin_mb"`
+	SkipNewerTarget        bool              `json:"skip_newer_target" yaml:"skip_newer_target"`
+	BackupDir              string            `json:"backup_dir" yaml:"backup_dir"`
+	LogLevel               string            `json:"log_level" yaml:"log_level"`
+	LogMaxMB               int               `json:"log_max_mb" yaml:"log_max_mb"`
+	LogKeep                int               `json:"log_keep" yaml:"log_keep"`
+	AllowNested            bool              `json:"allow_nested" yaml:"allow_nested"`
+	ModTimeTolerance       *float64          `json:"modtime_tolerance_seconds" yaml:"modtime_tolerance_seconds"`
+	Verify                 bool              `json:"verify" yaml:"verify"`
+	MaxBytesPerSec         int64             `json:"max_bytes_per_sec" yaml:"max_bytes_per_sec"`
+	PreserveEmptyDirs      bool              `json:"preserve_empty_dirs" yaml:"preserve_empty_dirs"`
+	TimeoutSeconds         int               `json:"timeout_seconds" yaml:"timeout_seconds"`
+	CopyADS                bool              `json:"copy_ads" yaml:"copy_ads"`
+	CopyACL                bool              `json:"copy_acl" yaml:"copy_acl"`
+	IntervalSeconds        int               `json:"interval_seconds" yaml:"interval_seconds"`
+	MinSizeBytes           int64             `json:"min_size_bytes" yaml:"min_size_bytes"`
+	MaxSizeBytes           int64             `json:"max_size_bytes" yaml:"max_size_bytes"`
+	ModifiedWithinHours    float64           `json:"modified_within_hours" yaml:"modified_within_hours"`
+	Notify                 bool              `json:"notify" yaml:"notify"`
+	WebhookURL             string            `json:"webhook_url" yaml:"webhook_url"`
+	NoOverwrite            bool              `json:"no_overwrite" yaml:"no_overwrite"`
+	MaxDepth               *int              `json:"max_depth" yaml:"max_depth"`
+	FollowLinks            bool              `json:"follow_links" yaml:"follow_links"`
+	RecreateLinks          bool              `json:"recreate_links" yaml:"recreate_links"`
+	Conflict               string            `json:"conflict" yaml:"conflict"`
+	ForceOverwriteReadonly bool              `json:"force_overwrite_readonly" yaml:"force_overwrite_readonly"`
+	Routes                 map[string]string `json:"routes" yaml:"routes"`
+	Compress               bool              `json:"compress" yaml:"compress"`
+	RecycleOnDelete        bool              `json:"recycle_on_delete" yaml:"recycle_on_delete"`
+	ErrorLog               string            `json:"error_log" yaml:"error_log"`
+	CaseCollision          string            `json:"case_collision" yaml:"case_collision"`
+	MaxOpenFiles           int               `json:"max_open_files" yaml:"max_open_files"`
+	PreserveCompression    bool              `json:"preserve_compression" yaml:"preserve_compression"`
+	CopyOwner              bool              `json:"copy_owner" yaml:"copy_owner"`
+	PreserveDirTimes       bool              `json:"preserve_dir_times" yaml:"preserve_dir_times"`
+	ForceAll               bool              `json:"force_all" yaml:"force_all"`
+	ModifiedAfter          time.Time         `json:"modified_after" yaml:"modified_after"`
+	ModifiedBefore         time.Time         `json:"modified_before" yaml:"modified_before"`
+	Sparse                 bool              `json:"sparse" yaml:"sparse"`
+	PostCommand            string            `json:"post_command" yaml:"post_command"`
+	PostCommandAlways      bool              `json:"post_command_always" yaml:"post_command_always"`
+	PreCommand             string            `json:"pre_command" yaml:"pre_command"`
+	Dedupe                 bool              `json:"dedupe" yaml:"dedupe"`
+	OfflineTimeoutSeconds  int               `json:"offline_timeout_seconds" yaml:"offline_timeout_seconds"`
+	Subdirs                []string          `json:"subdirs" yaml:"subdirs"`
+	ArchiveBitMode         bool              `json:"archive_bit_mode" yaml:"archive_bit_mode"`
+	MaxErrors              int               `json:"max_errors" yaml:"max_errors"`
+	Dehydrated             string            `json:"dehydrated" yaml:"dehydrated"`
+	NormalizeUnicode       string            `json:"normalize_unicode" yaml:"normalize_unicode"`
+	FileTimeoutSeconds     int               `json:"file_timeout_seconds" yaml:"file_timeout_seconds"`
+	LogThroughput          bool              `json:"log_throughput" yaml:"log_throughput"`
+	TargetDirs             []string          `json:"target_dirs" yaml:"target_dirs"`
+	ExcludeAttributes      []string          `json:"exclude_attributes" yaml:"exclude_attributes"`
+	PreserveBirthTime      bool              `json:"preserve_birth_time" yaml:"preserve_birth_time"`
+	FileList               string            `json:"file_list" yaml:"file_list"`
+	CheckClockSkew         bool              `json:"check_clock_skew" yaml:"check_clock_skew"`
+	CompensateClockSkew    bool              `json:"compensate_clock_skew" yaml:"compensate_clock_skew"`
+	SoftDeadlineSeconds    int               `json:"soft_deadline_seconds" yaml:"soft_deadline_seconds"`
+	NewestPerDir           int               `json:"newest_per_dir" yaml:"newest_per_dir"`
+	TargetPrefix           string            `json:"target_prefix" yaml:"target_prefix"`
+	ExcludeRegex           []string          `json:"exclude_regex" yaml:"exclude_regex"`
+	IncludeRegex           []string          `json:"include_regex" yaml:"include_regex"`
 }
 
+// Comparison modes accepted by the "compare" config field
+const (
+	// compareModTime copies only when the source is newer than the target
+	compareModTime = "modtime"
+	// compareSize also copies when the sizes differ; this is the default
+	compareSize = "size"
+	// compareChecksum also hashes files whose size and mod time match
+	compareChecksum = "checksum"
+)
+
+// errReadOnlyTarget is returned for a read-only target when force_overwrite_readonly is off
+var errReadOnlyTarget = errors.New("target is read-only (set force_overwrite_readonly to replace it)")
+
+// errVerifyMismatch is returned when a written copy does not hash the same as its source
+var errVerifyMismatch = errors.New("verification failed: target checksum does not match source")
+
+// tempFileSuffix marks a target file that is still being written
+const tempFileSuffix = ".gosync.tmp"
+
+// syncTask is a single file discovered by the walk and queued for a worker
+type syncTask struct {
+	job     *jobRun
+	path    string
+	relPath string
+	info    os.FileInfo
+}
+
+// taskQueue feeds syncTasks to the worker pool. pending counts the tasks
+// not yet finished, so the main pass can wait for its files while the pool
+// stays up for --watch. ctx is what the workers copy under.
+type taskQueue struct {
+	tasks    chan syncTask
+	pending  sync.WaitGroup
+	ctx      context.Context
+	watching bool
+}
+
+// push queues task for the workers.
+func (q *taskQueue) push(task syncTask) {
+	q.pending.Add(1)
+	q.tasks <- task
+}
+
+// watch switches the workers over to the changes --watch finds, copied under
+// ctx and without the main pass's soft deadline. It must only be called while
+// no task is pending.
+func (q *taskQueue) watch(ctx context.Context) {
+	q.ctx = ctx
+	q.watching = true
+}
+
+// work syncs a queued task to every target in its job's group.
+func (q *taskQueue) work(task syncTask, dryRun bool, startTime time.Time) {
+	defer q.pending.Done()
+
+	// Drain the queue without starting new copies once cancelled
+	if q.ctx.Err() != nil {
+		return
+	}
+	// Past the soft deadline, only count what is left to copy
+	if !q.watching && pastSoftDeadline(startTime) {
+		for _, task := range task.forGroup() {
+			if shouldCopyFile(task, task.job.targetPath(task.relPath)) {
+				filesRemaining.Add(1)
+			}
+		}
+		return
+	}
+	syncFiles(q.ctx, task.forGroup(), dryRun)
+}
+
+var config Config
 var logFile *os.File
 var wg sync.WaitGroup
 var mu sync.Mutex
+var wouldCopyFiles, wouldCopyBytes atomic.Int64
+
+// Policies for cloud placeholders whose data is not on the disk. Hydrating,
+// the default, downloads them as they are copied.
+const (
+	dehydratedSkip    = "skip"
+	dehydratedHydrate = "hydrate"
+)
+
+// abortRun cancels the current run with a cause, such as errTargetOffline or
+// errTooManyErrors
+var abortRun context.CancelCauseFunc
+
+// copyOwners is copy_owner for the current run, turned off when the privilege
+// it needs is missing
+var copyOwners bool
+
+// defaultBufferKB matches the buffer size io.Copy uses on its own
+const defaultBufferKB = 32
+
+// bufferPool lets concurrent workers reuse copy buffers instead of allocating per file
+var bufferPool = sync.Pool{
+	New: func() any {
+		buffer := make([]byte, bufferSize())
+		return &buffer
+	},
+}
+
+// bufferSize is the copy buffer size buffer_kb asks for, in bytes.
+func bufferSize() int {
+	size := config.BufferKB
+	if size <= 0 {
+		size = defaultBufferKB
+	}
+	return size * 1024
+}
+
+// getBuffer takes a copy buffer from bufferPool. One sized before a reload
+// changed buffer_kb is dropped in favour of a new one.
+func getBuffer() *[]byte {
+	buffer := bufferPool.Get().(*[]byte)
+	if len(*buffer) != bufferSize() {
+		fresh := make([]byte, bufferSize())
+		buffer = &fresh
+	}
+	return buffer
+}
+
+// Exit codes reported to schedulers and scripts
+const (
+	exitOK          = 0
+	exitConfigError = 1
+	exitCopyErrors  = 2
+	exitCancelled   = 3
+)
 
 func main() {
-	configFile := flag.String("config", "", "Path to configuration file")
+	os.Exit(run())
+}
+
+// run performs the sync, repeating it when an interval is set, and returns the
+// process exit code, so deferred cleanup such as closing the log happens
+// before main exits.
+func run() int {
+	configFile := flag.String("config", "", "Path or http(s) URL of the configuration file, or - to read it from stdin")
+	sourceFlag := flag.String("source", "", "Source directory, overriding the config")
+	targetFlag := flag.String("target", "", "Target directory, overriding the config")
+	mirror := flag.Bool("mirror", false, "Delete target files that no longer exist in the source")
+	move := flag.Bool("move", false, "Delete source files once they have been copied")
+	dryRun := flag.Bool("dry-run", false, "Report what would be copied without touching the target")
+	checkAccessFlag := flag.Bool("check-access", false, "Dry run that also checks each file to copy can be read from the source and written to the target")
+	workers := flag.Int("workers", runtime.NumCPU()*2, "Number of files to copy concurrently")
+	watch := flag.Bool("watch", false, "Keep running after the sync and copy files as they change")
+	summaryJSON := flag.String("summary-json", "", "Write a JSON run summary to this path, or - for stdout")
+	manifestPath := flag.String("manifest", "", "Write a manifest of the copied files and their SHA-256 digests to this path")
+	quiet := flag.Bool("quiet", false, "Only print errors and the final summary")
+	color := flag.Bool("color", false, "Highlight copies, skips and errors in color when stdout is a terminal")
+	force := flag.Bool("force", false, "Run even if the lock file says another sync is in progress")
+	intervalFlag := flag.Int("interval", 0, "Re-run the sync every N seconds, overriding interval_seconds")
+	verifyManifestPath := flag.String("verify-manifest", "", "Check the target files listed in this manifest against their digests, then exit")
+	forceAll := flag.Bool("force-all", false, "Copy every file, overwriting targets even when they match the source")
+	eventsFlag := flag.Bool("events-json", false, "Write a JSON line to stdout for every file processed, moving other output to stderr")
+	diffPath := flag.String("diff", "", "Write a CSV of new, changed and orphaned files to this path without copying, then exit")
+	printConfigFlag := flag.Bool("print-config", false, "Print the effective configuration as JSON after defaults and overrides are applied, then exit")
+	fixTimesFlag := flag.Bool("fix-times", false, "Copy source timestamps onto target files with identical content, without copying any data, then exit")
 	flag.Parse()
 
+	consoleQuiet = *quiet
+	consoleColor = *color && enableColor()
+	eventsJSON = *eventsFlag
+	checkAccess = *checkAccessFlag
+	if checkAccess {
+		*dryRun = true
+	}
+	if eventsJSON {
+		consoleOut = os.Stderr
+		consoleColor = false
+	}
+
+	if *workers < 1 {
+		*workers = 1
+	}
+
+	// Verifying a manifest needs no config, only the target it describes
+	if *verifyManifestPath != "" {
+		targetDir := ""
+		if *targetFlag != "" {
+			targetDir = expandPath(*targetFlag)
+		}
+		failed, err := verifyManifest(*verifyManifestPath, targetDir)
+		if err != nil {
+			printError("Error verifying manifest: %v", err)
+			return exitConfigError
+		}
+		if failed > 0 {
+			return exitCopyErrors
+		}
+		return exitOK
+	}
+
 	// Get paths
-	var sourceDir, targetDir string
 	executablePath, err := os.Executable()
 	if err != nil {
-		fmt.Printf("Error getting executable path: %v\n", err)
-		return
+		printError("Error getting executable path: %v", err)
+		return exitConfigError
 	}
 	executableDir := filepath.Dir(executablePath)
-	defaultConfigPath := filepath.Join(executableDir, "config.json")
 
-	// Load the configuration file
-	if *configFile != "" {
-		sourceDir, targetDir, err = loadConfig(*configFile)
-	} else if fileExists(defaultConfigPath) {
-		sourceDir, targetDir, err = loadConfig(defaultConfigPath)
+	opts := &options{
+		configFile:        *configFile,
+		defaultConfigPath: filepath.Join(executableDir, "config.json"),
+		source:            *sourceFlag,
+		target:            *targetFlag,
+		mirror:            *mirror,
+		move:              *move,
+		forceAll:          *forceAll,
+		dryRun:            *dryRun,
+		watch:             *watch,
+		workers:           *workers,
+		summaryJSON:       *summaryJSON,
+		manifestPath:      *manifestPath,
+		statePath:         filepath.Join(executableDir, stateFileName),
+		checksumCachePath: filepath.Join(executableDir, checksumCacheName),
+		diffPath:          *diffPath,
+		fixTimes:          *fixTimesFlag,
+		interval:          *intervalFlag,
+	}
+
+	// Load the first cycle's config before the log is opened, so a log that
+	// has already outgrown log_max_mb is rotated on startup
+	if code := loadCycleConfig(opts); code != exitOK {
+		return code
+	}
+
+	// Fail on a bad config before the lock and log are touched. A pre_command
+	// may be what mounts the directories, so with one they are only checked
+	// once it has run, unless the config is just being printed.
+	err = validateSettings(config)
+	if err == nil && (config.PreCommand == "" || *printConfigFlag) {
+		err = validateJobs(config, opts.dryRun)
+	}
+	if err == nil {
+		err = compileRegexFilters()
+	}
+	if err != nil {
+		printError("Invalid config: %v", err)
+		return exitConfigError
+	}
+
+	// Show what a run would use without running it, or taking the lock and log
+	if *printConfigFlag {
+		err = printEffectiveConfig()
+		if err != nil {
+			printError("Error printing config: %v", err)
+			return exitConfigError
+		}
+		return exitOK
+	}
+
+	// Refuse to run on top of another sync of the same install
+	err = acquireLock(filepath.Join(executableDir, lockFileName), *force)
+	if err != nil {
+		printError("Error: %v", err)
+		return exitConfigError
+	}
+	defer releaseLock()
+
+	// Logging
+	err = openLog(filepath.Join(executableDir, "sync.log"))
+	if err != nil {
+		printError("Error opening log file: %v", err)
+		return exitConfigError
+	}
+	defer closeLog()
+
+	// Cancel the sync on Ctrl+C
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// Run once, or keep re-running every interval until stopped. Each later cycle
+	// reloads the config so edits take effect without a restart.
+	interval := time.Duration(*intervalFlag) * time.Second
+	for cycle := 0; ; cycle++ {
+		code := syncCycle(signalCtx, opts, cycle > 0)
+		if code != exitConfigError {
+			interval = time.Duration(config.IntervalSeconds) * time.Second
+		}
+		if interval <= 0 || opts.watch || opts.diffPath != "" || opts.fixTimes || signalCtx.Err() != nil {
+			return code
+		}
+
+		next := time.Now().Add(interval)
+		logInfo(fmt.Sprintf("Next sync at %s", next.Format(time.RFC3339)))
+		printStatus("Next sync at %s. Press Ctrl+C to stop.", next.Format("15:04:05"))
+		select {
+		case <-signalCtx.Done():
+			logInfo("Stopped")
+			return code
+		case <-time.After(interval):
+		}
+	}
+}
+
+// options holds the command-line settings each sync cycle starts from
+type options struct {
+	configFile        string
+	defaultConfigPath string
+	source, target    string
+	mirror, move      bool
+	forceAll          bool
+	dryRun, watch     bool
+	workers           int
+	summaryJSON       string
+	manifestPath      string
+	statePath         string
+	checksumCachePath string
+	diffPath          string
+	fixTimes          bool
+	interval          int
+}
+
+// loadCycleConfig loads the configuration and applies the command-line
+// overrides on top of it, returning exitOK or the code to exit with.
+func loadCycleConfig(opts *options) int {
+	var err error
+
+	// Load the configuration file, unless both directories are given on the command line
+	if opts.source != "" && opts.target != "" && opts.configFile == "" {
+		config = Config{}
+	} else if opts.configFile != "" {
+		config, err = loadConfig(opts.configFile)
+	} else if fileExists(opts.defaultConfigPath) {
+		config, err = loadConfig(opts.defaultConfigPath)
 	} else {
 		fmt.Println("Configuration file not found. Please provide the path to the configuration file:")
 		reader := bufio.NewReader(os.Stdin)
 		configPath, _ := reader.ReadString('\n')
-		configPath = strings.TrimSpace(configPath)
-		sourceDir, targetDir, err = loadConfig(configPath)
+		// Remember the answer so later interval cycles do not ask again
+		opts.configFile = strings.TrimSpace(configPath)
+		config, err = loadConfig(opts.configFile)
 	}
 
 	if err != nil {
-		fmt.Printf("Error loading config: %v\n", err)
-		return
+		printError("Error loading config: %v", err)
+		return exitConfigError
 	}
 
-	if sourceDir == "" || targetDir == "" {
-		fmt.Println("Source and target directories must be specified in the configuration file.")
-		return
+	// Command-line directories override the config file
+	if opts.source != "" || opts.target != "" {
+		if len(config.Jobs) > 0 {
+			printError("--source and --target cannot be combined with a jobs config.")
+			return exitConfigError
+		}
+		if opts.source != "" {
+			config.SourceDir = expandPath(opts.source)
+		}
+		if opts.target != "" {
+			config.TargetDir = expandPath(opts.target)
+			config.TargetDirs = nil
+		}
 	}
 
-	// Logging
-	logFilePath := filepath.Join(executableDir, "sync.log")
-	logFile, err = os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if opts.move {
+		config.Move = true
+	}
+	if opts.forceAll {
+		config.ForceAll = true
+	}
+	if opts.mirror {
+		config.Mirror = true
+	}
+	if opts.interval > 0 {
+		config.IntervalSeconds = opts.interval
+	}
+
+	currentLogLevel, err = parseLogLevel(config.LogLevel)
 	if err != nil {
-		fmt.Printf("Error opening log file: %v\n", err)
-		return
+		printError("Error loading config: %v", err)
+		return exitConfigError
+	}
+	return exitOK
+}
+
+// syncCycle runs one complete sync, returning its exit code. With reload set
+// the config is loaded again first, so edits between cycles take effect.
+func syncCycle(signalCtx context.Context, opts *options, reload bool) int {
+	var err error
+
+	logInfo("Sync started")
+
+	if reload {
+		if code := loadCycleConfig(opts); code != exitOK {
+			return code
+		}
 	}
-	defer logFile.Close()
 
-	fmt.Printf("Starting sync from [%s] ===========> [%s]\n", sourceDir, targetDir)
+	err = openErrorLog(expandPath(config.ErrorLog))
+	if err != nil {
+		printError("Error opening error log: %v", err)
+		return exitConfigError
+	}
 
-	// Walk through the source directory
-	err = filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+	// Stage the target, e.g. mount a share, before it is checked
+	if config.PreCommand != "" {
+		err = runHook(signalCtx, "pre_command", config.PreCommand)
 		if err != nil {
-			return err
+			logError("Sync aborted: pre_command failed")
+			logInfo("--------------------")
+			printError("Sync aborted: pre_command failed: %v", err)
+			return exitCopyErrors
 		}
+	}
+
+	err = validateConfig(config, opts.dryRun)
+	if err == nil {
+		err = compileRegexFilters()
+	}
+	if err != nil {
+		printError("Invalid config: %v", err)
+		return exitConfigError
+	}
 
-		// Skip directories
-		if info.IsDir() {
-			return nil
+	jobs = newJobRuns(config.jobList())
+	defer func() {
+		for _, job := range jobs {
+			job.closeJobLog()
 		}
+	}()
+	for _, job := range jobs {
+		err = job.openJobLog()
+		if err != nil {
+			printError("%sError opening log file: %v", job.prefix(), err)
+			return exitConfigError
+		}
+		err = job.loadIgnoreFile()
+		if err != nil {
+			printError("%sError reading %s: %v", job.prefix(), ignoreFileName, err)
+			return exitConfigError
+		}
+	}
+	fileList = nil
+	if config.FileList != "" {
+		fileList, err = loadFileList(config.FileList)
+		if err != nil {
+			printError("Error reading file_list: %v", err)
+			return exitConfigError
+		}
+	}
 
+	// A diff only reports what a sync would do
+	if opts.diffPath != "" {
+		err = writeDiffReport(signalCtx, opts.diffPath)
+		logInfo("--------------------")
+		if err != nil {
+			printError("Error writing diff report: %v", err)
+			return exitCopyErrors
+		}
+		printSummary("Wrote diff report to %s.", opts.diffPath)
+		return exitOK
+	}
+
+	// Repairing timestamps leaves every file's content alone
+	if opts.fixTimes {
+		fixed, differ := fixTimes(signalCtx, opts.dryRun)
+		logInfo("--------------------")
+		if signalCtx.Err() != nil {
+			printError("Fixing times cancelled.")
+			return exitCancelled
+		}
+		verb := "Fixed"
+		if opts.dryRun {
+			verb = "Would fix"
+		}
+		printSummary("%s times on %d files, %d differ in content and need a sync.", verb, fixed, differ)
+		if errorCount := totalSummary(0).Errors; errorCount > 0 {
+			printError("Fixing times finished with %d errors. See sync.log for details.", errorCount)
+			return exitCopyErrors
+		}
+		return exitOK
+	}
+
+	if opts.dryRun {
+		printStatus("Dry run: no changes will be made to the target.")
+	}
+	// Make sure a force_all left in a config is never missed
+	if config.ForceAll {
+		logWarn("Force mode is active: every target file will be overwritten")
+		printError("WARNING: force mode is active, every target file will be overwritten.")
+	}
+	startTime := time.Now()
+
+	// Start every cycle from fresh counters
+	wouldCopyFiles.Store(0)
+	filesRemaining.Store(0)
+	runOutcome = ""
+	wouldCopyBytes.Store(0)
+	manifestFiles = nil
+	if opts.manifestPath != "" && !opts.dryRun {
+		manifestFiles = make(map[*jobRun][]manifestEntry)
+	}
+
+	throttle = nil
+	if config.MaxBytesPerSec > 0 {
+		throttle = newRateLimiter(config.MaxBytesPerSec)
+	}
+	// Without the privilege every file would fail, so say so once and go on
+	copyOwners = config.CopyOwner
+	if copyOwners && !opts.dryRun {
+		err = enableOwnerPrivilege()
+		if err != nil {
+			copyOwners = false
+			logError(fmt.Sprintf("copy_owner is disabled for this run: %v", err))
+			printError("copy_owner is disabled for this run: %v", err)
+		}
+	}
+
+	openFileSlots = nil
+	if config.MaxOpenFiles > 0 {
+		openFileSlots = make(chan struct{}, config.MaxOpenFiles)
+	}
+
+	// Record progress so an interrupted run can pick up where it stopped
+	if !opts.dryRun {
+		err = openState(opts.statePath)
+		if err != nil {
+			printError("Error opening state file: %v", err)
+			return exitConfigError
+		}
+		defer closeState(false)
+		if count := resumedCount(); count > 0 {
+			logInfo(fmt.Sprintf("Resuming an interrupted run, %d files already copied", count))
+			printStatus("Resuming an interrupted run, %d files already copied.", count)
+		}
+	}
+
+	// Keep the source digests of a cancelled run, only dropping stale ones
+	// once every file has been checked
+	loadChecksumCache(opts.checksumCachePath)
+	cacheComplete := false
+	defer func() {
+		err := saveChecksumCache(cacheComplete)
+		if err != nil {
+			logWarn(fmt.Sprintf("Error saving checksum cache: %v", err))
+		}
+	}()
+
+	// Abort a run that overstays timeout_seconds, such as one stuck on a hung mount
+	ctx := signalCtx
+	if config.TimeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(signalCtx, time.Duration(config.TimeoutSeconds)*time.Second)
+		defer cancel()
+	}
+
+	// Let a worker end the run when the target stays offline or too much fails
+	ctx, abortRun = context.WithCancelCause(ctx)
+	defer abortRun(nil)
+	targetAbandoned = false
+
+	// Warn when a drifting clock would make unchanged files look modified
+	if config.CheckClockSkew && !opts.dryRun {
+		for _, job := range jobs {
+			job.checkClockSkew()
+		}
+	}
+
+	// Pre-scan the sources so progress has a real denominator
+	var scanned atomic.Int64
+	stopSpinner := startSpinner(&scanned)
+	total := 0
+	for _, job := range jobs {
+		// Further target_dirs are counted by the first target's scan
+		if job.leader == nil {
+			total += countFiles(ctx, job, &scanned)
+		}
+	}
+	stopSpinner()
+
+	// Make sure every target has room before anything is written
+	pendingBytes, enough := checkFreeSpace(ctx, opts.dryRun)
+	if !enough {
+		logError("Sync aborted: not enough free space on target")
+		logInfo("--------------------")
+		printError("Sync aborted.")
+		runOutcome = "aborted"
+		reportRun(signalCtx, opts, startTime, "Sync aborted: not enough free space")
+		return exitCopyErrors
+	}
+
+	// Start a fixed pool of workers shared by every job, which stays up to
+	// copy the changes --watch picks up after the main pass
+	queue := &taskQueue{tasks: make(chan syncTask, opts.workers*4), ctx: ctx}
+	for i := 0; i < opts.workers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-
-			// Construct the target path
-			relPath, err := filepath.Rel(sourceDir, path)
-			if err != nil {
-				logMessage(fmt.Sprintf("Error getting relative path: %v", err))
-				return
+			for task := range queue.tasks {
+				queue.work(task, opts.dryRun, startTime)
 			}
-			targetPath := filepath.Join(targetDir, relPath)
-
-			// Check if the file needs to be copied
-			if shouldCopyFile(path, targetPath, info) {
-				err := copyFile(path, targetPath, info)
-				if err != nil {
-					logMessage(fmt.Sprintf("Error copying file: %v", err))
-					return
+		}()
+	}
+	defer func() {
+		close(queue.tasks)
+		wg.Wait()
+	}()
+
+	stopProgress := startProgress(total, pendingBytes)
+
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+		// Further target_dirs get their files from the first target's walk
+		if job.leader != nil {
+			continue
+		}
+		for _, target := range job.group() {
+			printStatus("%sStarting sync from [%s] ===========> [%s]", target.prefix(), target.SourceDir, target.TargetDir)
+		}
+		walkJob(ctx, job, queue, opts.dryRun)
+	}
+	queue.pending.Wait()
+
+	// Give files that were in use during the main pass one more try
+	if !pastSoftDeadline(startTime) {
+		for _, job := range jobs {
+			job.retryLocked(ctx, opts.dryRun)
+		}
+	}
+	stopProgress()
+
+	for _, job := range jobs {
+		job.applyDirTimes()
+	}
+
+	// A run cut short still reports how far it got
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		logError(fmt.Sprintf("Sync timed out after %d seconds", config.TimeoutSeconds))
+		logInfo("--------------------")
+		printError("Sync timed out.")
+		runOutcome = "timed_out"
+		reportRun(signalCtx, opts, startTime, "Sync timed out")
+		return exitCancelled
+	}
+	if errors.Is(context.Cause(ctx), errTooManyErrors) {
+		logError(fmt.Sprintf("Too many errors, aborting after %d", totalSummary(0).Errors))
+		logInfo("--------------------")
+		printError("Too many errors, aborting.")
+		runOutcome = "aborted"
+		reportRun(signalCtx, opts, startTime, "Sync aborted: too many errors")
+		return exitCopyErrors
+	}
+	if errors.Is(context.Cause(ctx), errTargetOffline) {
+		logError("Sync aborted: target offline")
+		logInfo("--------------------")
+		printError("Sync aborted: target offline.")
+		runOutcome = "aborted"
+		reportRun(signalCtx, opts, startTime, "Sync aborted: target offline")
+		return exitCopyErrors
+	}
+	if ctx.Err() != nil {
+		logError("Sync cancelled")
+		logInfo("--------------------")
+		printError("Sync cancelled.")
+		runOutcome = "cancelled"
+		reportRun(signalCtx, opts, startTime, "Sync cancelled")
+		return exitCancelled
+	}
+
+	cacheComplete = true
+
+	remaining := filesRemaining.Load()
+	if remaining > 0 {
+		logWarn(fmt.Sprintf("Soft deadline of %d seconds reached, %d files left to copy", config.SoftDeadlineSeconds, remaining))
+	}
+
+	// Remove orphaned files only once every copy has finished
+	if config.Mirror && remaining > 0 {
+		logWarn("Skipping mirror, the run was cut short by the soft deadline")
+	} else if config.Mirror {
+		for _, job := range jobs {
+			removed := mirrorTarget(job, opts.dryRun)
+			if opts.dryRun {
+				printStatus("%sWould remove %d orphaned files from target.", job.prefix(), removed)
+			} else {
+				job.logInfo(fmt.Sprintf("Mirror removed %d orphaned files", removed))
+				printStatus("%sRemoved %d orphaned files from target.", job.prefix(), removed)
+
+				pruned := pruneTargetDirs(job)
+				if pruned > 0 {
+					printStatus("%sRemoved %d empty target directories.", job.prefix(), pruned)
 				}
-				logMessage(fmt.Sprintf("Copied: %s", filepath.Base(path)))
 			}
-		}()
+		}
+	}
 
-		return nil
-	})
+	// Tidy up the source directories a move has emptied
+	if config.Move && config.RemoveEmptySourceDirs && !opts.dryRun {
+		for _, job := range jobs {
+			removed := removeEmptyDirs(job.SourceDir, nil, func(dir string) {
+				job.logInfo(fmt.Sprintf("Removed empty source directory: %s", dir))
+			})
+			if removed > 0 {
+				printStatus("%sRemoved %d empty source directories.", job.prefix(), removed)
+			}
+		}
+	}
 
-	if err != nil {
-		logMessage(fmt.Sprintf("Error walking the path: %v", err))
+	if opts.dryRun {
+		printSummary("Would copy %d files (%s).", wouldCopyFiles.Load(), formatBytes(wouldCopyBytes.Load()))
+	}
+
+	// Break the results down per job when there is more than one
+	if len(jobs) > 1 {
+		for _, job := range jobs {
+			summary := job.stats.summarize()
+			printSummary("%s%s, %s, %s", job.prefix(),
+				colorize(ansiGreen, fmt.Sprintf("Copied %d (%s)", summary.FilesCopied, formatBytes(summary.BytesCopied))),
+				colorize(ansiGray, fmt.Sprintf("skipped %d", summary.FilesSkipped)),
+				errorCountText(summary.Errors))
+		}
+	}
+
+	for _, job := range jobs {
+		for _, relPath := range job.lockedFiles() {
+			printError("%sStill in use, not copied: %s", job.prefix(), relPath)
+		}
+	}
+
+	printSummaryBlock(totalSummary(0))
+
+	errorCount := totalSummary(0).Errors
+	if errorCount > 0 {
+		logError(fmt.Sprintf("Sync finished with %d errors", errorCount))
+	}
+	// A run cut short keeps its state so the next one resumes where it stopped
+	closeState(errorCount == 0 && remaining == 0)
+	logInfo("--------------------")
+	if remaining > 0 {
+		printError("Run cut short by the soft deadline: %d files left to copy.", remaining)
+	}
+	if errorCount > 0 {
+		printError("Sync completed with %d errors. See sync.log for details.", errorCount)
+	} else if remaining == 0 {
+		printSummary("%s", colorize(ansiGreen, "Sync completed."))
 	}
 
-	wg.Wait()
-	logMessage("--------------------")
-	fmt.Println("Sync completed.")
+	if manifestFiles != nil {
+		err = writeManifest(opts.manifestPath)
+		if err != nil {
+			logError(fmt.Sprintf("Error writing manifest: %v", err))
+			printError("Error writing manifest: %v", err)
+		}
+	}
+
+	title := "Sync completed"
+	if errorCount > 0 {
+		title = "Sync completed with errors"
+	}
+	reportRun(signalCtx, opts, startTime, title)
+
+	// Stay resident and mirror changes as they happen
+	if opts.watch {
+		logInfo("Watching for changes...")
+		printStatus("Watching for changes... Press Ctrl+C to stop.")
+		queue.watch(signalCtx)
+		err = watchJobs(signalCtx, queue)
+		if err != nil {
+			logError(fmt.Sprintf("Error watching for changes: %v", err))
+			printError("Error watching for changes: %v", err)
+		}
+		logInfo("Stopped watching")
+		logInfo("--------------------")
+	}
+
+	if totalSummary(0).Errors > 0 {
+		return exitCopyErrors
+	}
+	return exitOK
 }
 
-func loadConfig(path string) (string, string, error) {
-	file, err := os.Open(path)
+// reportRun hands the outcome of a run to --summary-json, post_command, the
+// webhook and notify, with title as the notification's heading. A run cut
+// short or with errors only runs post_command when post_command_always is set.
+func reportRun(signalCtx context.Context, opts *options, startTime time.Time, title string) {
+	summary := totalSummary(0)
+	if opts.summaryJSON != "" {
+		err := writeSummaryJSON(opts.summaryJSON, time.Since(startTime))
+		if err != nil {
+			printError("Error writing summary: %v", err)
+		}
+	}
+	// Hand over to follow-up work, by default only after a clean run
+	clean := runOutcome == "" && summary.Errors == 0
+	if config.PostCommand != "" && !opts.dryRun && (clean || config.PostCommandAlways) {
+		// Ctrl+C ends the sync, not the cleanup that follows it
+		err := runPostCommand(context.WithoutCancel(signalCtx), summary)
+		if err != nil {
+			printError("post_command failed: %v", err)
+		}
+	}
+	if config.WebhookURL != "" {
+		err := postWebhook(config.WebhookURL, time.Since(startTime))
+		if err != nil {
+			logWarn(fmt.Sprintf("Error posting summary to webhook: %v", err))
+		}
+	}
+	if config.Notify {
+		notify(title, fmt.Sprintf("Copied %d files, %d failed", summary.FilesCopied, summary.Errors))
+	}
+}
+
+// contextReader fails the read once ctx is done, so a cancelled or timed out
+// run abandons a large copy midway instead of finishing it
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
+// matchesFilters reports whether relPath passes the configured include and
+// exclude patterns. With include patterns present a file must match one of them.
+func matchesFilters(relPath string) bool {
+	if isExcluded(relPath) {
+		return false
+	}
+	if len(config.Include) == 0 && len(includeRegex) == 0 {
+		return true
+	}
+	for _, pattern := range config.Include {
+		if matchPattern(pattern, relPath) {
+			return true
+		}
+	}
+	return matchesRegex(includeRegex, relPath)
+}
+
+// isExcluded reports whether relPath matches any exclude pattern or exclude_regex.
+func isExcluded(relPath string) bool {
+	for _, pattern := range config.Exclude {
+		if matchPattern(pattern, relPath) {
+			return true
+		}
+	}
+	return matchesRegex(excludeRegex, relPath)
+}
+
+// matchPattern matches a glob against the whole relative path. Patterns without
+// a separator are also tried against each path element, so "*.docx" or "temp"
+// match at any depth.
+func matchPattern(pattern, relPath string) bool {
+	pattern = filepath.FromSlash(pattern)
+	if matched, _ := filepath.Match(pattern, relPath); matched {
+		return true
+	}
+	if strings.ContainsRune(pattern, filepath.Separator) {
+		return false
+	}
+	for _, part := range strings.Split(relPath, string(filepath.Separator)) {
+		if matched, _ := filepath.Match(pattern, part); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// jobList returns the configured jobs, falling back to the flat
+// source_dir/target_dir pair, or one job per target_dirs entry, when no jobs
+// array is given. Every target has target_prefix appended.
+func (c Config) jobList() []Job {
+	var list []Job
+	switch {
+	case len(c.Jobs) > 0:
+		list = append(list, c.Jobs...)
+	case len(c.TargetDirs) > 0:
+		// Each of target_dirs is a job of its own, named after its target
+		for _, target := range c.TargetDirs {
+			list = append(list, Job{Name: target, SourceDir: c.SourceDir, TargetDir: target})
+		}
+	default:
+		list = []Job{{SourceDir: c.SourceDir, TargetDir: c.TargetDir}}
+	}
+
+	// target_prefix puts the whole source tree in a folder under each target
+	if c.TargetPrefix != "" {
+		for i := range list {
+			list[i].TargetDir = filepath.Join(list[i].TargetDir, c.TargetPrefix)
+		}
+	}
+	return list
+}
+
+// defaultModTimeTolerance covers the two-second timestamp granularity of FAT and exFAT
+const defaultModTimeTolerance = 2 * time.Second
+
+// modTimeTolerance returns how far apart mod times may be and still count as equal.
+func (c Config) modTimeTolerance() time.Duration {
+	if c.ModTimeTolerance == nil {
+		return defaultModTimeTolerance
+	}
+	return time.Duration(*c.ModTimeTolerance * float64(time.Second))
+}
+
+func loadConfig(path string) (Config, error) {
+	var config Config
+	file, ext, err := openConfig(path)
 	if err != nil {
-		return "", "", err
+		return config, err
 	}
 	defer file.Close()
 
-	var config Config
-	err = json.NewDecoder(file).Decode(&config)
+	// Pick the decoder from the file extension, defaulting to JSON
+	switch ext {
+	case ".yaml", ".yml":
+		err = yaml.NewDecoder(file).Decode(&config)
+		if err != nil {
+			return config, fmt.Errorf("invalid YAML in %s: %w", path, err)
+		}
+	default:
+		err = json.NewDecoder(file).Decode(&config)
+		if err != nil {
+			return config, fmt.Errorf("invalid JSON in %s: %w", path, err)
+		}
+	}
+
+	// Let one config serve machines with different user folders
+	config.SourceDir = expandPath(config.SourceDir)
+	config.TargetDir = expandPath(config.TargetDir)
+	config.BackupDir = expandPath(config.BackupDir)
+	config.FileList = expandPath(config.FileList)
+	for i := range config.TargetDirs {
+		config.TargetDirs[i] = expandPath(config.TargetDirs[i])
+	}
+	for i := range config.Jobs {
+		config.Jobs[i].SourceDir = expandPath(config.Jobs[i].SourceDir)
+		config.Jobs[i].TargetDir = expandPath(config.Jobs[i].TargetDir)
+		config.Jobs[i].LogFile = expandPath(config.Jobs[i].LogFile)
+	}
+
+	return config, nil
+}
+
+// configFetchTimeout bounds how long fetching a config from a URL may take
+const configFetchTimeout = 30 * time.Second
+
+// stdinConfig keeps a config read from stdin, since stdin can only be read
+// once but interval mode reloads the config every cycle
+var stdinConfig []byte
+
+// openConfig opens the config at path, which may be "-" for stdin or an
+// http(s) URL as well as a local file, and returns its lowercased extension.
+func openConfig(path string) (io.ReadCloser, string, error) {
+	if path == "-" {
+		if stdinConfig == nil {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return nil, "", err
+			}
+			stdinConfig = data
+		}
+		return io.NopCloser(bytes.NewReader(stdinConfig)), "", nil
+	}
+
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		configURL, err := url.Parse(path)
+		if err != nil {
+			return nil, "", err
+		}
+		client := http.Client{Timeout: configFetchTimeout}
+		resp, err := client.Get(path)
+		if err != nil {
+			return nil, "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, "", fmt.Errorf("fetching %s: server returned %s", path, resp.Status)
+		}
+		return resp.Body, strings.ToLower(filepath.Ext(configURL.Path)), nil
+	}
+
+	file, err := os.Open(path)
 	if err != nil {
-		return "", "", err
+		return nil, "", err
+	}
+	return file, strings.ToLower(filepath.Ext(path)), nil
+}
+
+// windowsEnvVar matches a %VAR% reference
+var windowsEnvVar = regexp.MustCompile(`%([^%]+)%`)
+
+// expandPath expands a leading ~ to the home directory and both $VAR and
+// Windows-style %VAR% environment references. Unknown %VAR% references are
+// left as they are.
+func expandPath(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`) {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			path = home + path[1:]
+		}
 	}
 
-	return config.SourceDir, config.TargetDir, nil
+	path = windowsEnvVar.ReplaceAllStringFunc(path, func(match string) string {
+		value, ok := os.LookupEnv(match[1 : len(match)-1])
+		if !ok {
+			return match
+		}
+		return value
+	})
+
+	return os.ExpandEnv(path)
 }
 
 func fileExists(path string) bool {
@@ -140,108 +1122,619 @@ func fileExists(path string) bool {
 	return !os.IsNotExist(err)
 }
 
-func shouldCopyFile(sourcePath, targetPath string, sourceInfo os.FileInfo) bool {
+// shouldCopyFile reports whether the task's source should be copied over the
+// target. It logs nothing, since pre-scans ask it about every file before the
+// sync itself decides and logs.
+func shouldCopyFile(task syncTask, targetPath string) bool {
+	return decideCopy(task, targetPath).direction == copyToTarget
+}
+
+// copyDecision is which way decideCopy found a file should be copied, with
+// the target's info when it exists and what to log about the decision
+type copyDecision struct {
+	direction  copyDirection
+	targetInfo os.FileInfo
+	// err is an unreadable target, printed to the console
+	err     error
+	level   logLevel
+	message string
+}
+
+// copyDirectionFor decides which way, if at all, the task's file should be
+// copied, logs why when there is something to say, and returns the target's
+// info when it exists.
+func copyDirectionFor(task syncTask, targetPath string) (copyDirection, os.FileInfo) {
+	decision := decideCopy(task, targetPath)
+	if decision.err != nil {
+		printError("Error: %v", decision.err)
+	}
+	if decision.message != "" {
+		task.job.log(decision.level, decision.message)
+	}
+	return decision.direction, decision.targetInfo
+}
+
+// decideCopy is copyDirectionFor without the logging.
+func decideCopy(task syncTask, targetPath string) copyDecision {
+	job, sourcePath, sourceInfo := task.job, task.path, task.info
 	targetInfo, err := os.Stat(targetPath)
 	if os.IsNotExist(err) {
 		// File doesn't exist, so we need to copy it
-		return true
+		return copyDecision{direction: copyToTarget}
 	} else if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return false
+		return copyDecision{direction: copyNone, err: err}
+	}
+	decide := func(direction copyDirection) copyDecision {
+		return copyDecision{direction: direction, targetInfo: targetInfo}
+	}
+	// hashFailed leaves a file whose contents could not be compared alone
+	hashFailed := func(err error) copyDecision {
+		return copyDecision{direction: copyNone, targetInfo: targetInfo, level: levelError, message: fmt.Sprintf("Error hashing file: %v", err)}
 	}
 
-	// Check if the source file has been modified after the target file
-	return sourceInfo.ModTime().After(targetInfo.ModTime())
+	// Force mode overwrites whatever is there
+	if config.ForceAll {
+		return decide(copyToTarget)
+	}
+
+	// Classic incremental backups copy whatever has changed since its archive bit was cleared
+	if config.ArchiveBitMode && hasArchiveBit(sourceInfo) {
+		return decide(copyToTarget)
+	}
+
+	// Seeding a target never touches a file that is already there
+	if config.NoOverwrite {
+		return decide(copyNone)
+	}
+
+	// A conflict policy decides the winner whenever the two sides differ
+	if config.Conflict != "" {
+		differ, err := filesDiffer(task, targetPath, targetInfo)
+		if err != nil {
+			return hashFailed(err)
+		}
+		if !differ {
+			return decide(copyNone)
+		}
+		direction, message := job.resolveConflict(targetPath, sourceInfo, targetInfo)
+		decision := decide(direction)
+		decision.level, decision.message = levelWarn, message
+		return decision
+	}
+
+	mode := config.Compare.strategyFor(task.relPath)
+	// A compressed target's size and contents never match the source, so only
+	// its mod time, which is copied from the source, can be compared
+	if config.Compress {
+		mode = compareModTime
+	}
+
+	// Times within the tolerance count as equal, since FAT and exFAT targets
+	// only store mod times to two seconds
+	tolerance := config.modTimeTolerance()
+	drift := job.timeDrift(sourceInfo, targetInfo)
+
+	// Check if the source file has been modified after the target file. A
+	// deduplicated target is a link carrying another file's mod time, so with
+	// dedupe a file of the same size is compared by content instead.
+	if drift > tolerance {
+		if config.Dedupe && sourceInfo.Size() == targetInfo.Size() {
+			differ, err := checksumsDiffer(job, sourcePath, targetPath, sourceInfo, targetInfo)
+			if err != nil {
+				return hashFailed(err)
+			}
+			if !differ {
+				return decide(copyNone)
+			}
+		}
+		return decide(copyToTarget)
+	}
+	if mode == compareModTime {
+		return decide(copyNone)
+	}
+
+	// Protect edits made directly to the target copy
+	if config.SkipNewerTarget && -drift > tolerance {
+		return copyDecision{direction: copyNone, targetInfo: targetInfo, level: levelWarn, message: fmt.Sprintf("Conflict, target is newer than source: %s", targetPath)}
+	}
+
+	// A size difference means the content changed, e.g. an earlier truncated
+	// write, unless the target is the newer side and was edited there
+	if sourceInfo.Size() != targetInfo.Size() {
+		if -drift > tolerance {
+			return copyDecision{direction: copyNone, targetInfo: targetInfo, level: levelWarn, message: fmt.Sprintf("Target is newer than source and differs in size, not overwriting: %s", targetPath)}
+		}
+		return decide(copyToTarget)
+	}
+
+	// Identical timestamps can hide changed content, e.g. after a restore
+	if mode == compareChecksum && drift.Abs() <= tolerance {
+		differ, err := checksumsDiffer(job, sourcePath, targetPath, sourceInfo, targetInfo)
+		if err != nil {
+			return hashFailed(err)
+		}
+		if differ {
+			return copyDecision{direction: copyToTarget, targetInfo: targetInfo, level: levelInfo, message: fmt.Sprintf("Checksum mismatch, re-copying: %s", filepath.Base(sourcePath))}
+		}
+	}
+
+	return decide(copyNone)
 }
 
-func copyFile(sourcePath, targetPath string, sourceInfo os.FileInfo) error {
-	// Create the target directory if it doesn't exist
-	targetDir := filepath.Dir(targetPath)
-	err := os.MkdirAll(targetDir, os.ModePerm)
+// checksumsDiffer reports whether two files have different contents, taking
+// the source's digest from the checksum cache when it is still current and
+// the target's from an earlier comparison in the same run.
+func checksumsDiffer(job *jobRun, sourcePath, targetPath string, sourceInfo, targetInfo os.FileInfo) (bool, error) {
+	sourceSum, err := job.sourceChecksum(sourcePath, sourceInfo)
 	if err != nil {
-		return err
+		return false, err
+	}
+	targetSum, err := job.targetChecksum(targetPath, targetInfo)
+	if err != nil {
+		return false, err
+	}
+	return sourceSum != targetSum, nil
+}
+
+// fileChecksum returns the hex encoded SHA-256 digest of the file at path.
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// copyDest is one target a copy writes the source to. When backupPath is set,
+// an existing target is moved there just before being replaced.
+type copyDest struct {
+	job        *jobRun
+	targetPath string
+	backupPath string
+}
+
+// copyResult is how the copy to one copyDest went. digest is the source's
+// SHA-256 when it was hashed during the copy, otherwise "".
+type copyResult struct {
+	digest string
+	err    error
+}
+
+// copyFile copies the source to targetPath. When backupPath is set, an existing
+// target is moved there just before being replaced. It returns the source's
+// SHA-256 digest when it was hashed during the copy, otherwise "".
+func copyFile(ctx context.Context, sourcePath, targetPath, backupPath string, sourceInfo os.FileInfo) (string, error) {
+	result := copyFiles(ctx, sourcePath, []copyDest{{targetPath: targetPath, backupPath: backupPath}}, sourceInfo)[0]
+	return result.digest, result.err
+}
+
+// copyFiles copies the source to every dest, reading it only once and writing
+// each block to all the targets' temporary files as it streams past. A dest
+// that fails drops out without stopping the others, and the result for each
+// dest is returned in the same order.
+func copyFiles(ctx context.Context, sourcePath string, dests []copyDest, sourceInfo os.FileInfo) []copyResult {
+	sourcePath = toLongPath(sourcePath)
+	results := make([]copyResult, len(dests))
+	pending := func() []int {
+		var indexes []int
+		for i := range results {
+			if results[i].err == nil {
+				indexes = append(indexes, i)
+			}
+		}
+		return indexes
+	}
+	failPending := func(err error) []copyResult {
+		for _, i := range pending() {
+			results[i].err = err
+		}
+		return results
+	}
+
+	for i, dest := range dests {
+		targetPath := toLongPath(dest.targetPath)
+
+		// Create the target directory if it doesn't exist
+		err := os.MkdirAll(filepath.Dir(targetPath), os.ModePerm)
+		if err == nil && !config.ForceOverwriteReadonly && isReadOnly(targetPath) {
+			// Read-only targets are protected unless the user opts in to replacing them
+			err = errReadOnlyTarget
+		}
+		results[i].err = err
 	}
+	if len(pending()) == 0 {
+		return results
+	}
+
+	// The slot is held until both the source and the temporary files are closed
+	release, err := acquireOpenFile(ctx)
+	if err != nil {
+		return failPending(err)
+	}
+	defer release()
 
 	sourceFile, err := os.Open(sourcePath)
 	if err != nil {
-		return err
+		return failPending(err)
 	}
 	defer sourceFile.Close()
 
-	targetFile, err := os.Create(targetPath)
+	// Write to temporary files so each target is always either the old complete
+	// file or the new complete file, never a partial one
+	temps := make([]*tempCopy, len(dests))
+	defer func() {
+		for _, temp := range temps {
+			if temp != nil && !temp.renamed {
+				temp.discard()
+			}
+		}
+	}()
+	var live fanoutWriter
+	for _, i := range pending() {
+		targetPath := toLongPath(dests[i].targetPath)
+		temps[i], results[i].err = createTemp(targetPath+tempFileSuffix, sourceInfo, compressesTo(sourcePath, targetPath))
+		if results[i].err == nil {
+			live = append(live, temps[i])
+		}
+	}
+	if len(live) == 0 {
+		return results
+	}
+
+	digest, err := streamSource(ctx, sourceFile, live)
+	if err != nil {
+		return failPending(err)
+	}
+	for _, i := range pending() {
+		if temps[i].err != nil {
+			results[i].err = temps[i].err
+			continue
+		}
+		results[i].err = temps[i].finish(sourceFile, sourceInfo, digest)
+	}
+	// A copy abandoned after file_timeout_seconds must not replace the target late
+	if err := ctx.Err(); err != nil {
+		return failPending(err)
+	}
+
+	for _, i := range pending() {
+		results[i].err = replaceTarget(ctx, sourcePath, temps[i], dests[i])
+		if results[i].err == nil {
+			results[i].digest = digest
+		}
+	}
+	return results
+}
+
+// replaceTarget moves a finished temporary copy over the dest's target,
+// backing up the old target first when asked to.
+func replaceTarget(ctx context.Context, sourcePath string, temp *tempCopy, dest copyDest) error {
+	targetPath := toLongPath(dest.targetPath)
+
+	// An existing read-only, hidden or system target cannot be replaced. The
+	// source's own read-only bit is already set on the new copy.
+	err := clearFileAttributes(targetPath)
 	if err != nil {
 		return err
 	}
-	defer targetFile.Close()
 
-	_, err = io.Copy(targetFile, sourceFile)
+	if dest.backupPath != "" {
+		err = backupFile(ctx, targetPath, toLongPath(dest.backupPath))
+		if err != nil {
+			return fmt.Errorf("backing up %s: %w", targetPath, err)
+		}
+	}
+
+	err = os.Rename(temp.path, targetPath)
 	if err != nil {
 		return err
 	}
+	temp.renamed = true
 
-	// Explicitly sync the file to ensure all changes are flushed to disk
-	err = targetFile.Sync()
+	// Permissions are best effort, since copying them may need rights the account lacks
+	if config.CopyACL {
+		err = copyACL(sourcePath, targetPath)
+		if err != nil {
+			logWarn(fmt.Sprintf("Could not copy permissions to %s: %v", targetPath, err))
+		}
+	}
+	if copyOwners {
+		err = copyOwner(sourcePath, targetPath)
+		if err != nil {
+			logWarn(fmt.Sprintf("Could not copy owner to %s: %v", targetPath, err))
+		}
+	}
+	return nil
+}
+
+// backupFile moves an existing file at path to backupPath before it is
+// overwritten, falling back to a copy when the backup lives on another volume.
+func backupFile(ctx context.Context, path, backupPath string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(backupPath), os.ModePerm)
 	if err != nil {
 		return err
 	}
-	// Preserve the timestamps of the source file
-	err = setFileTimes(targetPath, sourceInfo)
+	backupPath, err = reserveBackupPath(backupPath)
 	if err != nil {
 		return err
 	}
+	if os.Rename(path, backupPath) == nil {
+		return nil
+	}
+	_, err = copyFile(ctx, path, backupPath, "", info)
+	if err != nil {
+		os.Remove(backupPath)
+	}
+	return err
+}
 
-	return nil
+// reserveBackupPath creates an empty file at backupPath, or at backupPath with
+// -2, -3 and so on before its extension when that is taken, and returns its
+// path, so backups made within the same second never replace each other.
+func reserveBackupPath(backupPath string) (string, error) {
+	ext := filepath.Ext(backupPath)
+	base := strings.TrimSuffix(backupPath, ext)
+	for n := 1; ; n++ {
+		path := backupPath
+		if n > 1 {
+			path = fmt.Sprintf("%s-%d%s", base, n, ext)
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			return path, file.Close()
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+	}
 }
 
-func setFileTimes(targetPath string, sourceInfo os.FileInfo) error {
-	stat := sourceInfo.Sys().(*syscall.Win32FileAttributeData)
+// removeTempFile deletes a temporary copy, clearing any attributes already
+// applied to it that would block the removal.
+func removeTempFile(tempPath string) {
+	clearFileAttributes(tempPath)
+	os.Remove(tempPath)
+}
 
-	// Convert times to windows.Filetime
-	creationTime := windows.NsecToFiletime(stat.CreationTime.Nanoseconds())
-	lastAccessTime := windows.NsecToFiletime(stat.LastAccessTime.Nanoseconds())
-	lastWriteTime := windows.NsecToFiletime(stat.LastWriteTime.Nanoseconds())
+// tempCopy is one target's temporary file while the source streams into it.
+// err holds the first write error, after which the file gets no more data.
+type tempCopy struct {
+	path     string
+	file     *os.File
+	writer   io.Writer
+	gzip     *gzip.Writer
+	sparse   *sparseWriter
+	compress bool
+	err      error
+	renamed  bool
+}
 
-	handle, err := windows.CreateFile(
-		windows.StringToUTF16Ptr(targetPath),
-		windows.FILE_WRITE_ATTRIBUTES,
-		windows.FILE_SHARE_WRITE,
-		nil,
-		windows.OPEN_EXISTING,
-		windows.FILE_ATTRIBUTE_NORMAL,
-		0,
-	)
+// createTemp creates the temporary file at tempPath, gzipping what is written
+// to it when compress is set.
+func createTemp(tempPath string, sourceInfo os.FileInfo, compress bool) (*tempCopy, error) {
+	// A crash can leave a stale temp file behind with attributes already applied
+	err := clearFileAttributes(tempPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer windows.CloseHandle(handle)
 
-	// Set the file times
-	err = windows.SetFileTime(handle, &creationTime, &lastAccessTime, &lastWriteTime)
+	targetFile, err := os.Create(tempPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	temp := &tempCopy{path: tempPath, file: targetFile, writer: targetFile, compress: compress}
 
-	return nil
+	// Compression is best effort, since the target volume may not support it
+	if config.PreserveCompression {
+		err = setCompression(targetFile, sourceInfo)
+		if err != nil {
+			logWarn(fmt.Sprintf("Could not compress %s: %v", strings.TrimSuffix(tempPath, tempFileSuffix), err))
+		}
+	}
+
+	if compress {
+		temp.gzip = gzip.NewWriter(targetFile)
+		temp.writer = temp.gzip
+	} else if config.Sparse && isSparse(sourceInfo) {
+		// Fall back to a plain copy when the target volume cannot hold sparse files
+		err = setSparse(targetFile)
+		if err != nil {
+			logWarn(fmt.Sprintf("Could not make %s sparse: %v", strings.TrimSuffix(tempPath, tempFileSuffix), err))
+		} else {
+			temp.sparse = &sparseWriter{file: targetFile}
+			temp.writer = temp.sparse
+		}
+	}
+	return temp, nil
+}
+
+// discard closes the temporary file if it is still open and removes it.
+func (t *tempCopy) discard() {
+	if t.file != nil {
+		t.file.Close()
+	}
+	removeTempFile(t.path)
+}
+
+// fanoutWriter writes every block to each temporary file still taking data.
+// A failing file records its error and drops out, so the copy only fails
+// once none are left.
+type fanoutWriter []*tempCopy
+
+func (w fanoutWriter) Write(p []byte) (int, error) {
+	var err error
+	written := false
+	for _, temp := range w {
+		if temp.err != nil {
+			err = temp.err
+			continue
+		}
+		n, writeErr := temp.writer.Write(p)
+		if writeErr == nil && n < len(p) {
+			writeErr = io.ErrShortWrite
+		}
+		if writeErr != nil {
+			temp.err, err = writeErr, writeErr
+			continue
+		}
+		streamedBytes.Add(int64(n))
+		written = true
+	}
+	if !written {
+		return 0, err
+	}
+	return len(p), nil
 }
 
-func countFiles(dir string) (int, error) {
-	count := 0
-	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+// streamSource reads sourceFile once into every temporary file in temps. It
+// returns the source digest when the copy was hashed, otherwise "". Its error
+// is for a failed read; a temporary file that failed to write has its own.
+func streamSource(ctx context.Context, sourceFile *os.File, temps fanoutWriter) (string, error) {
+	buffer := getBuffer()
+	defer bufferPool.Put(buffer)
+
+	// Hash the source as it streams past so verification and the manifest need no second read
+	var reader io.Reader = sourceFile
+	sourceHash := sha256.New()
+	hashed := config.Verify || manifestFiles != nil
+	if hashed {
+		reader = io.TeeReader(sourceFile, sourceHash)
+	}
+	if throttle != nil {
+		reader = throttledReader{reader: reader, limiter: throttle}
+	}
+	reader = contextReader{ctx: ctx, reader: reader}
+
+	// Hide ReadFrom/WriteTo so io.CopyBuffer really uses the pooled buffer
+	_, err := io.CopyBuffer(struct{ io.Writer }{temps}, struct{ io.Reader }{reader}, *buffer)
+	if err != nil {
+		for _, temp := range temps {
+			if temp.err == nil {
+				return "", err
+			}
+		}
+		// Every temporary file failed to write, and each reports its own error
+	}
+
+	if !hashed {
+		return "", nil
+	}
+	return hex.EncodeToString(sourceHash.Sum(nil)), nil
+}
+
+// finish flushes and closes the temporary file and applies the source
+// timestamps and attributes, leaving it ready to be renamed into place.
+func (t *tempCopy) finish(sourceFile *os.File, sourceInfo os.FileInfo, digest string) error {
+	var err error
+	if t.gzip != nil {
+		err = t.gzip.Close()
+	}
+	if err == nil && t.sparse != nil {
+		err = t.sparse.finish()
+	}
+
+	// Explicitly sync the file to ensure all changes are flushed to disk
+	if err == nil {
+		err = t.file.Sync()
+	}
+	closeErr := t.file.Close()
+	t.file = nil
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Re-read what landed on disk before it replaces the target
+	if config.Verify {
+		checksum := fileChecksum
+		if t.compress {
+			checksum = gzipChecksum
+		}
+		targetSum, err := checksum(t.path)
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() {
-			count++
+		if targetSum != digest {
+			return errVerifyMismatch
+		}
+	}
+
+	// Streams go on before the timestamps, since writing them updates the mod time
+	if config.CopyADS {
+		count, err := copyStreams(sourceFile.Name(), t.path)
+		if err != nil {
+			return fmt.Errorf("copying alternate data streams: %w", err)
+		}
+		logDebug(fmt.Sprintf("Copied %d alternate data streams: %s", count, filepath.Base(sourceFile.Name())))
+	}
+
+	// Preserve the timestamps of the source file
+	err = setFileTimes(t.path, sourceInfo)
+	if err != nil {
+		return err
+	}
+
+	// Preserve the read-only, hidden and system bits last
+	return setFileAttributes(t.path, sourceInfo)
+}
+
+// countFiles returns how many files in the job's source pass the filters,
+// adding each one to counted as it is found so a spinner can show the scan.
+// It also claims target paths for case collision checks and, with dedupe,
+// indexes the content already in the target. A job with followers counts
+// each file once for every target in its group.
+func countFiles(ctx context.Context, job *jobRun, counted *atomic.Int64) int {
+	var count atomic.Int64
+	walkSource(ctx, job, false, func(task syncTask) {
+		if task.info.Mode().IsRegular() {
+			for _, task := range task.forGroup() {
+				count.Add(1)
+				counted.Add(1)
+				task.job.claimTarget(task.relPath)
+				if config.Dedupe {
+					task.job.indexContent(task)
+				}
+			}
 		}
-		return nil
 	})
-	return count, err
+	return int(count.Load())
 }
 
-func logMessage(message string) {
+func logMessage(level logLevel, message string) {
+	if level > currentLogLevel {
+		return
+	}
 	mu.Lock()
 	defer mu.Unlock()
 	timestamp := time.Now().Format(time.RFC3339)
-	logEntry := fmt.Sprintf("%s - %s\n", timestamp, message)
-	logFile.WriteString(logEntry)
+	logEntry := fmt.Sprintf("%s - %s - %s\n", timestamp, level, message)
+	n, _ := logFile.WriteString(logEntry)
+	logSize += int64(n)
+	if level == levelError && errorLogFile != nil {
+		errorLogFile.WriteString(logEntry)
+	}
+
+	if logNeedsRotation() {
+		err := rotateLog()
+		if err != nil {
+			printError("Error rotating log file: %v", err)
+		}
+	}
 }
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useConfig sets the global config for the test and restores the old one afterwards.
+func useConfig(t *testing.T, c Config) {
+	t.Helper()
+	saved := config
+	config = c
+	t.Cleanup(func() { config = saved })
+}
+
+// writeFile creates the file at path with content and modTime and returns its info.
+func writeFile(t *testing.T, path, content string, modTime time.Time) os.FileInfo {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		err = os.WriteFile(path, []byte(content), 0644)
+	}
+	if err == nil {
+		err = os.Chtimes(path, modTime, modTime)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestCopyDirectionFor(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	exact := 0.0
+	tests := []struct {
+		name   string
+		config Config
+		skew   time.Duration
+		// target is the target's content, or no target at all when missing is set
+		source, target string
+		missing        bool
+		// sourceAge and targetAge are added to modTime
+		sourceAge, targetAge time.Duration
+		want                 copyDirection
+	}{
+		{name: "missing target", source: "data", missing: true, want: copyToTarget},
+		{name: "identical", source: "data", target: "data", want: copyNone},
+		{name: "newer source", source: "data", target: "data", sourceAge: time.Hour, want: copyToTarget},
+		{name: "older source", source: "data", target: "data", targetAge: time.Hour, want: copyNone},
+		{name: "within tolerance", source: "data", target: "data", sourceAge: time.Second, want: copyNone},
+		{name: "past tolerance", source: "data", target: "data", sourceAge: 3 * time.Second, want: copyToTarget},
+		{name: "tolerance turned off", config: Config{ModTimeTolerance: &exact}, source: "data", target: "data", sourceAge: time.Second, want: copyToTarget},
+		{name: "same time different size", source: "data", target: "dat", want: copyToTarget},
+		{name: "newer source different size", source: "data", target: "dat", sourceAge: time.Hour, want: copyToTarget},
+		{name: "newer target different size", source: "data", target: "edited", targetAge: time.Hour, want: copyNone},
+		{name: "force all", config: Config{ForceAll: true}, source: "data", target: "data", want: copyToTarget},
+		{name: "force all newer target", config: Config{ForceAll: true}, source: "data", target: "data", targetAge: time.Hour, want: copyToTarget},
+		{name: "no overwrite", config: Config{NoOverwrite: true}, source: "data", target: "old", sourceAge: time.Hour, want: copyNone},
+		{name: "no overwrite missing target", config: Config{NoOverwrite: true}, source: "data", missing: true, want: copyToTarget},
+		{name: "conflict newest source", config: Config{Conflict: conflictNewest}, source: "data", target: "edited", sourceAge: time.Hour, want: copyToTarget},
+		{name: "conflict newest target", config: Config{Conflict: conflictNewest}, source: "data", target: "edited", targetAge: time.Hour, want: copyToSource},
+		{name: "conflict newest tie", config: Config{Conflict: conflictNewest}, source: "data", target: "edited", want: copyNone},
+		{name: "conflict source wins", config: Config{Conflict: conflictSource}, source: "data", target: "edited", targetAge: time.Hour, want: copyToTarget},
+		{name: "conflict target wins", config: Config{Conflict: conflictTarget}, source: "data", target: "edited", sourceAge: time.Hour, want: copyToSource},
+		{name: "conflict skipped", config: Config{Conflict: conflictSkip}, source: "data", target: "edited", sourceAge: time.Hour, want: copyNone},
+		{name: "no conflict when identical", config: Config{Conflict: conflictTarget}, source: "data", target: "data", want: copyNone},
+		{name: "compress compares mod time", config: Config{Compress: true}, source: "data", target: "dat", want: copyNone},
+		{name: "compress copies newer source", config: Config{Compress: true}, source: "data", target: "dat", sourceAge: time.Hour, want: copyToTarget},
+		{name: "skew compensated", skew: time.Hour, source: "data", target: "data", sourceAge: time.Hour, want: copyNone},
+		{name: "skew left off matching times", skew: time.Hour, source: "data", target: "dat", want: copyToTarget},
+		{name: "checksum finds same size edit", config: Config{Compare: compareSetting{Default: compareChecksum}}, source: "data", target: "dada", want: copyToTarget},
+		{name: "checksum matches", config: Config{Compare: compareSetting{Default: compareChecksum}}, source: "data", target: "data", want: copyNone},
+		{name: "modtime ignores size", config: Config{Compare: compareSetting{Default: compareModTime}}, source: "data", target: "dat", want: copyNone},
+		{name: "rule matches relative path", config: Config{Compare: compareSetting{Default: compareSize, Rules: []compareRule{{Pattern: "*.txt", Strategy: compareModTime}}}}, source: "data", target: "dat", want: copyNone},
+		{name: "rule for other files", config: Config{Compare: compareSetting{Default: compareSize, Rules: []compareRule{{Pattern: "*.psd", Strategy: compareModTime}}}}, source: "data", target: "dat", want: copyToTarget},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, test.config)
+			dir := t.TempDir()
+			job := &jobRun{Job: Job{SourceDir: filepath.Join(dir, "source"), TargetDir: filepath.Join(dir, "target")}, clockSkew: test.skew}
+
+			sourcePath := filepath.Join(job.SourceDir, "file.txt")
+			info := writeFile(t, sourcePath, test.source, modTime.Add(test.sourceAge))
+			targetPath := filepath.Join(job.TargetDir, "file.txt")
+			if !test.missing {
+				writeFile(t, targetPath, test.target, modTime.Add(test.targetAge))
+			}
+
+			task := syncTask{job: job, path: sourcePath, relPath: "file.txt", info: info}
+			got, _ := copyDirectionFor(task, targetPath)
+			if got != test.want {
+				t.Errorf("copyDirectionFor() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// failingWriter fails every write with err
+type failingWriter struct{ err error }
+
+func (w failingWriter) Write(p []byte) (int, error) { return 0, w.err }
+
+func TestFanoutWriter(t *testing.T) {
+	var good, other bytes.Buffer
+	diskFull := errors.New("disk full")
+	temps := fanoutWriter{{writer: &good}, {writer: failingWriter{diskFull}}, {writer: &other}}
+
+	for _, block := range []string{"one ", "two"} {
+		n, err := temps.Write([]byte(block))
+		if n != len(block) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v, want %d, nil while some targets still take data", block, n, err, len(block))
+		}
+	}
+	if good.String() != "one two" || other.String() != "one two" {
+		t.Errorf("targets got %q and %q, want %q", good.String(), other.String(), "one two")
+	}
+	if temps[1].err != diskFull {
+		t.Errorf("failed target recorded %v, want %v", temps[1].err, diskFull)
+	}
+
+	// Once every target has failed the copy as a whole fails
+	broken := fanoutWriter{{writer: failingWriter{diskFull}}}
+	if _, err := broken.Write([]byte("data")); err != diskFull {
+		t.Errorf("Write() with no working target = %v, want %v", err, diskFull)
+	}
+}
+
+func TestCopyFilesToSeveralTargets(t *testing.T) {
+	useConfig(t, Config{})
+	dir := t.TempDir()
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	sourcePath := filepath.Join(dir, "source", "a.txt")
+	info := writeFile(t, sourcePath, "data", modTime)
+
+	// The last target's directory cannot be created, since a file is in the way
+	writeFile(t, filepath.Join(dir, "blocked"), "", modTime)
+	dests := []copyDest{
+		{targetPath: filepath.Join(dir, "one", "a.txt")},
+		{targetPath: filepath.Join(dir, "two", "a.txt")},
+		{targetPath: filepath.Join(dir, "blocked", "a.txt")},
+	}
+	for i := range dests {
+		dests[i].job = &jobRun{}
+	}
+
+	results := copyFiles(context.Background(), sourcePath, dests, info)
+	for i, dest := range dests[:2] {
+		if results[i].err != nil {
+			t.Errorf("copy to %s failed: %v", dest.targetPath, results[i].err)
+			continue
+		}
+		content, err := os.ReadFile(dest.targetPath)
+		if err != nil || string(content) != "data" {
+			t.Errorf("%s holds %q, %v, want %q", dest.targetPath, content, err, "data")
+		}
+		if target, err := os.Stat(dest.targetPath); err != nil || !target.ModTime().Equal(modTime) {
+			t.Errorf("%s was not given the source's mod time", dest.targetPath)
+		}
+		if fileExists(dest.targetPath + tempFileSuffix) {
+			t.Errorf("%s left its temporary file behind", dest.targetPath)
+		}
+	}
+	if results[2].err == nil {
+		t.Error("copy into a blocked directory succeeded")
+	}
+}
+
+func TestGetBufferFollowsBufferKB(t *testing.T) {
+	useConfig(t, Config{BufferKB: 4})
+	buffer := getBuffer()
+	if len(*buffer) != 4*1024 {
+		t.Fatalf("getBuffer() gave %d bytes, want %d", len(*buffer), 4*1024)
+	}
+	bufferPool.Put(buffer)
+
+	// A reload that changes buffer_kb must not keep handing out the old size
+	config.BufferKB = 8
+	if buffer := getBuffer(); len(*buffer) != 8*1024 {
+		t.Errorf("getBuffer() after a reload gave %d bytes, want %d", len(*buffer), 8*1024)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// manifest lists every file copied by a run with its digest, so the target can
+// later be checked against it independently of the source
+type manifest struct {
+	Created time.Time     `json:"created"`
+	Jobs    []manifestJob `json:"jobs"`
+}
+
+type manifestJob struct {
+	Name      string          `json:"name,omitempty"`
+	TargetDir string          `json:"target_dir"`
+	Files     []manifestEntry `json:"files"`
+}
+
+type manifestEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256"`
+	// Gzipped marks a compressed copy, whose digest is of the original data
+	Gzipped bool `json:"gzipped,omitempty"`
+}
+
+// manifestFiles collects the copied files per job when --manifest is set, and
+// is nil otherwise
+var manifestFiles map[*jobRun][]manifestEntry
+var manifestMu sync.Mutex
+
+// recordManifest adds a copied file to the manifest, if one is being written.
+func (j *jobRun) recordManifest(task syncTask, targetPath, digest string) {
+	if manifestFiles == nil {
+		return
+	}
+	relPath, err := filepath.Rel(j.TargetDir, targetPath)
+	if err != nil {
+		relPath = task.relPath
+	}
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	manifestFiles[j] = append(manifestFiles[j], manifestEntry{
+		Path:    filepath.ToSlash(relPath),
+		Size:    task.info.Size(),
+		ModTime: task.info.ModTime().UTC(),
+		SHA256:  digest,
+		Gzipped: compressesTo(task.path, targetPath),
+	})
+}
+
+// writeManifest writes the files copied by this run to path.
+func writeManifest(path string) error {
+	out := manifest{Created: time.Now().UTC()}
+	for _, job := range jobs {
+		files := manifestFiles[job]
+		if files == nil {
+			files = []manifestEntry{}
+		}
+		out.Jobs = append(out.Jobs, manifestJob{Name: job.Name, TargetDir: job.TargetDir, Files: files})
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// verifyManifest re-hashes every file listed in the manifest at path under its
+// target directory, or under targetDir when that is set for a single-job
+// manifest, and returns how many are missing or no longer match.
+func verifyManifest(path, targetDir string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var in manifest
+	err = json.Unmarshal(data, &in)
+	if err != nil {
+		return 0, fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+	if targetDir != "" && len(in.Jobs) > 1 {
+		return 0, fmt.Errorf("--target cannot be used with a manifest of %d jobs", len(in.Jobs))
+	}
+
+	checked, failed := 0, 0
+	for _, job := range in.Jobs {
+		root := job.TargetDir
+		if targetDir != "" {
+			root = targetDir
+		}
+		prefix := ""
+		if job.Name != "" {
+			prefix = "[" + job.Name + "] "
+		}
+
+		for _, entry := range job.Files {
+			checked++
+			checksum := fileChecksum
+			if entry.Gzipped {
+				checksum = gzipChecksum
+			}
+			sum, err := checksum(toLongPath(filepath.Join(root, filepath.FromSlash(entry.Path))))
+			if os.IsNotExist(err) {
+				printError("%sMissing: %s", prefix, entry.Path)
+				failed++
+			} else if err != nil {
+				printError("%sError hashing %s: %v", prefix, entry.Path, err)
+				failed++
+			} else if sum != entry.SHA256 {
+				printError("%sMismatch: %s", prefix, entry.Path)
+				failed++
+			}
+		}
+	}
+
+	printSummary("Verified %d files, %d failed.", checked, failed)
+	return failed, nil
+}
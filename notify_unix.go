@@ -0,0 +1,6 @@
+//go:build unix
+
+package main
+
+// notify is a no-op: notify only supports Windows toasts.
+func notify(title, message string) {}
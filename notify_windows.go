@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// toastScript shows a toast through the Windows notification API, reading its
+// text from the environment so nothing in it needs quoting. It borrows
+// PowerShell's registered app ID, since an unregistered one is silently dropped.
+const toastScript = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode($env:GOSYNC_TOAST_TITLE)) > $null
+$text.Item(1).AppendChild($template.CreateTextNode($env:GOSYNC_TOAST_MESSAGE)) > $null
+$appID = '{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe'
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($appID).Show([Windows.UI.Notifications.ToastNotification]::new($template))
+`
+
+// notify shows a desktop toast. Failures are ignored so runs without a
+// desktop, such as a service, are unaffected.
+func notify(title, message string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", toastScript)
+	cmd.Env = append(os.Environ(), "GOSYNC_TOAST_TITLE="+title, "GOSYNC_TOAST_MESSAGE="+message)
+	cmd.Run()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// offlinePollInterval is how often a vanished target is checked for
+const offlinePollInterval = 2 * time.Second
+
+// errTargetOffline ends a run whose target did not come back within
+// offline_timeout_seconds
+var errTargetOffline = errors.New("target offline")
+
+// offlineMu is held by the one worker polling for a vanished target, so the
+// others queue behind it instead of each failing. targetAbandoned is set once
+// the wait has timed out.
+var offlineMu sync.Mutex
+var targetAbandoned bool
+
+// targetGone reports whether a copy failed because the job's target volume
+// disconnected, either with a device error or by its root disappearing.
+func (j *jobRun) targetGone(err error) bool {
+	if config.OfflineTimeoutSeconds <= 0 {
+		return false
+	}
+	return isVolumeGoneError(err) || (errors.Is(err, os.ErrNotExist) && !fileExists(j.TargetDir))
+}
+
+// waitForTarget pauses until the job's target is reachable again and reports
+// whether it came back. After offline_timeout_seconds it gives up and aborts
+// the run, so the thousands of files still queued are not each logged as failed.
+func (j *jobRun) waitForTarget(ctx context.Context) bool {
+	offlineMu.Lock()
+	defer offlineMu.Unlock()
+
+	// Another worker may already have waited it out, or given up
+	if targetAbandoned {
+		return false
+	}
+	if fileExists(j.TargetDir) {
+		return true
+	}
+
+	timeout := time.Duration(config.OfflineTimeoutSeconds) * time.Second
+	j.logWarn(fmt.Sprintf("Target offline, waiting up to %v for it to return: %s", timeout, j.TargetDir))
+	printError("%sTarget offline, waiting...", j.prefix())
+
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(offlinePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-deadline:
+			targetAbandoned = true
+			j.logError(fmt.Sprintf("Target still offline after %v, aborting", timeout))
+			if abortRun != nil {
+				abortRun(errTargetOffline)
+			}
+			return false
+		case <-ticker.C:
+			if fileExists(j.TargetDir) {
+				j.logInfo("Target back online, resuming")
+				printStatus("%sTarget back online, resuming.", j.prefix())
+				return true
+			}
+		}
+	}
+}
@@ -0,0 +1,34 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// errNoRestorePrivilege means the process is not root, without which Unix
+// refuses to give a file to another user
+var errNoRestorePrivilege = errors.New("copying owners requires running as root")
+
+// enableOwnerPrivilege checks that this process may change file owners.
+func enableOwnerPrivilege() error {
+	if os.Geteuid() != 0 {
+		return errNoRestorePrivilege
+	}
+	return nil
+}
+
+// copyOwner applies the owning user and group of sourcePath to targetPath.
+func copyOwner(sourcePath, targetPath string) error {
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return os.Chown(targetPath, int(stat.Uid), int(stat.Gid))
+}
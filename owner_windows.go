@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// procAdjustTokenPrivileges is called directly since the wrapper in x/sys
+// hides the ERROR_NOT_ALL_ASSIGNED it reports alongside success
+var procAdjustTokenPrivileges = windows.NewLazySystemDLL("advapi32.dll").NewProc("AdjustTokenPrivileges")
+
+// errNoRestorePrivilege means the account lacks SeRestorePrivilege, without
+// which Windows refuses to set an owner other than the caller
+var errNoRestorePrivilege = errors.New("the account does not hold SeRestorePrivilege; run as an administrator or backup operator")
+
+// enableOwnerPrivilege enables SeRestorePrivilege for this process, which
+// copy_owner needs to assign files to other accounts.
+func enableOwnerPrivilege() error {
+	var token windows.Token
+	err := windows.OpenProcessToken(windows.CurrentProcess(), windows.TOKEN_ADJUST_PRIVILEGES|windows.TOKEN_QUERY, &token)
+	if err != nil {
+		return err
+	}
+	defer token.Close()
+
+	var luid windows.LUID
+	name, err := windows.UTF16PtrFromString("SeRestorePrivilege")
+	if err != nil {
+		return err
+	}
+	err = windows.LookupPrivilegeValue(nil, name, &luid)
+	if err != nil {
+		return err
+	}
+
+	privileges := windows.Tokenprivileges{PrivilegeCount: 1}
+	privileges.Privileges[0] = windows.LUIDAndAttributes{Luid: luid, Attributes: windows.SE_PRIVILEGE_ENABLED}
+	ret, _, err := procAdjustTokenPrivileges.Call(uintptr(token), 0, uintptr(unsafe.Pointer(&privileges)), 0, 0, 0)
+	if ret == 0 {
+		return err
+	}
+	if err == windows.ERROR_NOT_ALL_ASSIGNED {
+		return errNoRestorePrivilege
+	}
+	return nil
+}
+
+// copyOwner applies the owner and primary group of sourcePath to targetPath.
+func copyOwner(sourcePath, targetPath string) error {
+	sd, err := windows.GetNamedSecurityInfo(sourcePath, windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION|windows.GROUP_SECURITY_INFORMATION)
+	if err != nil {
+		return err
+	}
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return err
+	}
+	group, _, err := sd.Group()
+	if err != nil {
+		return err
+	}
+	return windows.SetNamedSecurityInfo(targetPath, windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION|windows.GROUP_SECURITY_INFORMATION, owner, group, nil, nil)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// printEffectiveConfig writes the config a run would use to stdout as
+// indented JSON, after loadCycleConfig has applied the command-line overrides
+// and with unset fields showing the defaults they fall back to.
+func printEffectiveConfig() error {
+	resolved := config
+	if resolved.ModTimeTolerance == nil {
+		seconds := defaultModTimeTolerance.Seconds()
+		resolved.ModTimeTolerance = &seconds
+	}
+	if resolved.BufferKB <= 0 {
+		resolved.BufferKB = defaultBufferKB
+	}
+	if resolved.RetryDelayMS <= 0 {
+		resolved.RetryDelayMS = int(defaultRetryDelay / time.Millisecond)
+	}
+	if resolved.LogLevel == "" {
+		resolved.LogLevel = "info"
+	}
+	if resolved.Compare.Default == "" {
+		resolved.Compare.Default = compareSize
+	}
+
+	data, err := json.MarshalIndent(resolved, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(os.Stdout, string(data))
+	return err
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is how often the status line is printed during a sync
+const progressInterval = 5 * time.Second
+
+// spinnerInterval is how often the scanning spinner advances
+const spinnerInterval = 200 * time.Millisecond
+
+// throughputWindow is how far back the copy rate behind the ETA is measured
+const throughputWindow = 30 * time.Second
+
+// streamedBytes counts the bytes copies have written so far, including files
+// still in flight, so the rate behind the ETA moves during large files
+var streamedBytes atomic.Int64
+
+// progressSample is the bytes streamed by a point in time
+type progressSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// startProgress prints the share of the total files finished, with the bytes
+// copied out of totalBytes and an estimate of the time left, every
+// progressInterval. The returned function stops the reporter and waits for it.
+func startProgress(total int, totalBytes int64) func() {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		streamedBytes.Store(0)
+		samples := []progressSample{{time.Now(), 0}}
+		for {
+			select {
+			case now := <-ticker.C:
+				processed := int64(0)
+				for _, job := range jobs {
+					processed += job.stats.processed.Load()
+				}
+				percent := 100.0
+				if total > 0 {
+					percent = float64(processed) * 100 / float64(total)
+				}
+				copied := totalSummary(0).BytesCopied
+				streamed := streamedBytes.Load()
+
+				// Measure the rate over a rolling window so the ETA follows
+				// changes in speed, such as moving from small files to large
+				samples = append(samples, progressSample{now, streamed})
+				for len(samples) > 2 && now.Sub(samples[0].at) > throughputWindow {
+					samples = samples[1:]
+				}
+				oldest := samples[0]
+				rate := float64(streamed-oldest.bytes) / now.Sub(oldest.at).Seconds()
+
+				line := fmt.Sprintf("%d/%d files (%.0f%%), %s of %s copied", processed, total, percent, formatBytes(copied), formatBytes(totalBytes))
+				if remaining := totalBytes - streamed; remaining > 0 && rate > 0 {
+					eta := time.Duration(float64(remaining) / rate * float64(time.Second))
+					line += fmt.Sprintf(", ~%s remaining at %s/s", formatETA(eta), formatBytes(int64(rate)))
+				}
+				printStatus("%s", line)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// formatETA rounds d to the coarsest unit that is still useful, e.g. 45s,
+// 12m or 2h05m.
+func formatETA(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	default:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+}
+
+// startSpinner shows a "Scanning..." spinner with the running file count while
+// the sources are enumerated. Without a terminal it prints a single line
+// instead, since carriage returns would only clutter a log. The returned
+// function stops it and clears the line.
+func startSpinner(counted *atomic.Int64) func() {
+	if consoleQuiet {
+		return func() {}
+	}
+	// The spinner's carriage returns would corrupt the --events-json stream
+	if !isTerminal() || eventsJSON {
+		printStatus("Scanning...")
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(spinnerInterval)
+		defer ticker.Stop()
+
+		frames := `|/-\`
+		for frame := 0; ; frame++ {
+			fmt.Printf("\rScanning... %c %d files", frames[frame%len(frames)], counted.Load())
+			select {
+			case <-ticker.C:
+			case <-done:
+				// Pad over the longer spinner line, which may not support ANSI erase
+				fmt.Printf("\r%-40s\n", fmt.Sprintf("Scanned %d files.", counted.Load()))
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
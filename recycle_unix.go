@@ -0,0 +1,10 @@
+//go:build unix
+
+package main
+
+import "errors"
+
+// recycleFile is not implemented here, as there is no Recycle Bin to send to.
+func recycleFile(path string) error {
+	return errors.ErrUnsupported
+}
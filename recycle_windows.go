@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var procSHFileOperationW = windows.NewLazySystemDLL("shell32.dll").NewProc("SHFileOperationW")
+
+const (
+	foDelete          = 0x0003
+	fofSilent         = 0x0004
+	fofNoConfirmation = 0x0010
+	fofAllowUndo      = 0x0040
+	fofNoConfirmMkdir = 0x0200
+	fofNoErrorUI      = 0x0400
+	recycleFlags      = fofSilent | fofNoConfirmation | fofAllowUndo | fofNoErrorUI | fofNoConfirmMkdir
+)
+
+// shFileOpStruct mirrors SHFILEOPSTRUCTW
+type shFileOpStruct struct {
+	Hwnd                 uintptr
+	Func                 uint32
+	From                 *uint16
+	To                   *uint16
+	Flags                uint16
+	AnyOperationsAborted int32
+	NameMappings         uintptr
+	ProgressTitle        *uint16
+}
+
+// recycleFile sends path to the Recycle Bin instead of deleting it outright.
+func recycleFile(path string) error {
+	// The shell API rejects \\?\ paths, and wants the list double-null terminated
+	if strings.HasPrefix(path, longPathPrefix+`UNC\`) {
+		path = `\\` + path[len(longPathPrefix+`UNC\`):]
+	} else {
+		path = strings.TrimPrefix(path, longPathPrefix)
+	}
+	from, err := windows.UTF16FromString(path)
+	if err != nil {
+		return err
+	}
+	from = append(from, 0)
+
+	op := shFileOpStruct{
+		Func:  foDelete,
+		From:  &from[0],
+		Flags: recycleFlags,
+	}
+	ret, _, _ := procSHFileOperationW.Call(uintptr(unsafe.Pointer(&op)))
+	if ret != 0 {
+		return fmt.Errorf("could not recycle %s: SHFileOperation error %#x", path, ret)
+	}
+	if op.AnyOperationsAborted != 0 {
+		return fmt.Errorf("could not recycle %s: operation aborted", path)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+)
+
+// excludeRegex and includeRegex are exclude_regex and include_regex compiled
+// for the current cycle
+var excludeRegex, includeRegex []*regexp.Regexp
+
+// compileRegexFilters compiles exclude_regex and include_regex, naming the
+// first pattern that is not a valid regular expression.
+func compileRegexFilters() error {
+	var err error
+	excludeRegex, err = compilePatterns("exclude_regex", config.ExcludeRegex)
+	if err != nil {
+		return err
+	}
+	includeRegex, err = compilePatterns("include_regex", config.IncludeRegex)
+	return err
+}
+
+// compilePatterns compiles every pattern of the named option.
+func compilePatterns(option string, patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s pattern %q: %w", option, pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// matchesRegex reports whether any of patterns matches relPath. Paths are
+// matched with forward slashes so one pattern works on every platform.
+func matchesRegex(patterns []*regexp.Regexp, relPath string) bool {
+	slashed := filepath.ToSlash(relPath)
+	for _, re := range patterns {
+		if re.MatchString(slashed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileRegexFiltersInvalid(t *testing.T) {
+	useConfig(t, Config{ExcludeRegex: []string{`\.tmp$`}, IncludeRegex: []string{`(unclosed`}})
+	t.Cleanup(func() { excludeRegex, includeRegex = nil, nil })
+
+	err := compileRegexFilters()
+	if err == nil || !strings.Contains(err.Error(), "include_regex") || !strings.Contains(err.Error(), "(unclosed") {
+		t.Errorf("compileRegexFilters() = %v, want an error naming the include_regex pattern", err)
+	}
+}
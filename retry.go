@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// errFileTimeout reports a single copy that ran past file_timeout_seconds
+var errFileTimeout = errors.New("copy timed out")
+
+// defaultRetryDelay is the first backoff when retry_delay_ms is not set
+const defaultRetryDelay = 500 * time.Millisecond
+
+// isRetryable reports whether a failed copy is worth another attempt. A copy
+// that failed verification may simply have hit a bad read on a flaky link.
+func isRetryable(err error) bool {
+	return isTransientError(err) || errors.Is(err, errVerifyMismatch)
+}
+
+// copyWithRetry calls copyFile and returns its digest, retrying transient failures up to
+// config.MaxRetries times with exponential backoff. Permanent errors are
+// returned immediately, as is ctx's error if it ends during a backoff.
+func copyWithRetry(ctx context.Context, job *jobRun, sourcePath, targetPath, backupPath string, sourceInfo os.FileInfo) (string, error) {
+	dest := copyDest{job: job, targetPath: targetPath, backupPath: backupPath}
+	result := copyAllWithRetry(ctx, sourcePath, []copyDest{dest}, sourceInfo)[0]
+	return result.digest, result.err
+}
+
+// copyAllWithRetry is copyWithRetry for several targets of the same source.
+// Each attempt reads the source once for every dest still to be retried, and
+// a dest that failed permanently or ran out of retries keeps its error.
+func copyAllWithRetry(ctx context.Context, sourcePath string, dests []copyDest, sourceInfo os.FileInfo) []copyResult {
+	delay := time.Duration(config.RetryDelayMS) * time.Millisecond
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+
+	results := make([]copyResult, len(dests))
+	pending := make([]int, len(dests))
+	for i := range pending {
+		pending[i] = i
+	}
+	for attempt := 1; ; attempt++ {
+		batch := make([]copyDest, len(pending))
+		for k, i := range pending {
+			batch[k] = dests[i]
+		}
+
+		var retry []int
+		for k, result := range copyWithTimeout(ctx, sourcePath, batch, sourceInfo) {
+			i := pending[k]
+			results[i] = result
+			if result.err == nil || attempt > config.MaxRetries || !isRetryable(result.err) {
+				continue
+			}
+			dests[i].job.logWarn(fmt.Sprintf("Retrying %s in %v (attempt %d of %d): %v", filepath.Base(sourcePath), delay, attempt, config.MaxRetries, result.err))
+			retry = append(retry, i)
+		}
+		if len(retry) == 0 {
+			return results
+		}
+
+		select {
+		case <-ctx.Done():
+			for _, i := range retry {
+				results[i] = copyResult{err: ctx.Err()}
+			}
+			return results
+		case <-time.After(delay):
+		}
+		delay *= 2
+		pending = retry
+	}
+}
+
+// copyWithTimeout runs copyFiles, giving up on it after file_timeout_seconds.
+// A read stuck on a hung mount never sees the context, so the copy runs on its
+// own goroutine and the worker moves on without it. The abandoned copy is
+// still writing to temporary files, which it removes once its read returns.
+func copyWithTimeout(ctx context.Context, sourcePath string, dests []copyDest, sourceInfo os.FileInfo) []copyResult {
+	if config.FileTimeoutSeconds <= 0 {
+		return copyFiles(ctx, sourcePath, dests, sourceInfo)
+	}
+
+	timeout := time.Duration(config.FileTimeoutSeconds) * time.Second
+	fileCtx, cancel := context.WithTimeoutCause(ctx, timeout, errFileTimeout)
+	defer cancel()
+
+	done := make(chan []copyResult, 1)
+	go func() {
+		done <- copyFiles(fileCtx, sourcePath, dests, sourceInfo)
+	}()
+
+	failed := func(err error) []copyResult {
+		results := make([]copyResult, len(dests))
+		for i := range results {
+			results[i].err = err
+		}
+		return results
+	}
+	timedOut := fmt.Errorf("%s: %w after %v", sourcePath, errFileTimeout, timeout)
+
+	select {
+	case results := <-done:
+		if context.Cause(fileCtx) == errFileTimeout {
+			for i := range results {
+				if results[i].err != nil {
+					results[i] = copyResult{err: timedOut}
+				}
+			}
+		}
+		return results
+	case <-fileCtx.Done():
+		if context.Cause(fileCtx) == errFileTimeout {
+			return failed(timedOut)
+		}
+		return failed(ctx.Err())
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// sparseBlock is the granularity at which runs of zeros are skipped. Holes
+// smaller than a file system cluster would be allocated anyway.
+const sparseBlock = 4096
+
+// sparseWriter writes to a file that has been marked sparse, seeking over
+// zero-filled blocks instead of writing them so they stay unallocated
+type sparseWriter struct {
+	file   *os.File
+	offset int64
+}
+
+func (w *sparseWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), sparseBlock)
+		block := p[:n]
+		if isZero(block) {
+			_, err := w.file.Seek(int64(n), io.SeekCurrent)
+			if err != nil {
+				return written, err
+			}
+		} else {
+			_, err := w.file.Write(block)
+			if err != nil {
+				return written, err
+			}
+		}
+		w.offset += int64(n)
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// finish sets the file's length, since a trailing hole skipped by a seek does
+// not extend the file on its own.
+func (w *sparseWriter) finish() error {
+	return w.file.Truncate(w.offset)
+}
+
+func isZero(block []byte) bool {
+	for _, b := range block {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// isSparse reports whether the source file has fewer blocks allocated than
+// its size needs, which means it has holes.
+func isSparse(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int64(stat.Blocks)*512 < info.Size()
+}
+
+// setSparse is a no-op: Unix file systems leave regions skipped by a seek
+// unallocated without being asked.
+func setSparse(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// isSparse reports whether the source file has FILE_ATTRIBUTE_SPARSE_FILE.
+func isSparse(info os.FileInfo) bool {
+	return fileAttributes(info)&windows.FILE_ATTRIBUTE_SPARSE_FILE != 0
+}
+
+// setSparse marks the open target sparse, without which NTFS allocates the
+// regions skipped by sparseWriter as zeros.
+func setSparse(file *os.File) error {
+	var returned uint32
+	return windows.DeviceIoControl(windows.Handle(file.Fd()), windows.FSCTL_SET_SPARSE, nil, 0, nil, 0, &returned, nil)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// stateFileName is created next to the executable and records each file as it
+// is copied, so a run that dies part way can resume where it stopped
+const stateFileName = "sync.state"
+
+// stateEntry is one line of the state file
+type stateEntry struct {
+	Job     string    `json:"job,omitempty"`
+	Path    string    `json:"path"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+type stateKey struct {
+	job, path string
+}
+
+// stateFile is open for appending while a run records its progress, and nil
+// otherwise. resumed holds the files an interrupted earlier run copied.
+var stateFile *os.File
+var statePath string
+var stateMu sync.Mutex
+var resumed map[stateKey]time.Time
+
+// openState loads whatever an interrupted run left in the state file at path,
+// then opens it to record this run's copies.
+func openState(path string) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	resumed = make(map[stateKey]time.Time)
+	existing, err := os.Open(path)
+	if err == nil {
+		scanner := bufio.NewScanner(existing)
+		for scanner.Scan() {
+			var entry stateEntry
+			// A line cut short by the interruption is simply ignored
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+				resumed[stateKey{entry.Job, entry.Path}] = entry.ModTime
+			}
+		}
+		existing.Close()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	stateFile = file
+	statePath = path
+	return nil
+}
+
+// closeState stops recording, removing the state file when the run completed
+// cleanly so the next run starts from scratch.
+func closeState(clean bool) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	if stateFile == nil {
+		return
+	}
+	stateFile.Close()
+	stateFile = nil
+	resumed = nil
+	if clean {
+		os.Remove(statePath)
+	}
+}
+
+// resumedCount returns how many files the state file says are already copied.
+func resumedCount() int {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return len(resumed)
+}
+
+// copiedBefore reports whether an interrupted run already copied the file at
+// relPath, unchanged since, to targetPath.
+func (j *jobRun) copiedBefore(relPath string, info os.FileInfo, targetPath string) bool {
+	stateMu.Lock()
+	modTime, ok := resumed[stateKey{j.Name, filepath.ToSlash(relPath)}]
+	stateMu.Unlock()
+	return ok && modTime.Equal(info.ModTime()) && fileExists(targetPath)
+}
+
+// recordState appends a copied file to the state file, if one is open.
+func (j *jobRun) recordState(task syncTask) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if stateFile == nil {
+		return
+	}
+
+	line, err := json.Marshal(stateEntry{
+		Job:     j.Name,
+		Path:    filepath.ToSlash(task.relPath),
+		ModTime: task.info.ModTime(),
+	})
+	if err != nil {
+		return
+	}
+	stateFile.Write(append(line, '\n'))
+}
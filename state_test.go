@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateResume(t *testing.T) {
+	useConfig(t, Config{})
+	job := newTestJob(t, []string{"a.txt", "b.txt"}, []string{"a.txt"})
+	path := filepath.Join(t.TempDir(), stateFileName)
+	t.Cleanup(func() { closeState(false) })
+
+	if err := openState(path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(job.SourceDir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := syncTask{job: job, path: filepath.Join(job.SourceDir, "a.txt"), relPath: "a.txt", info: info}
+	job.recordState(task)
+	closeState(false)
+
+	// An interruption can leave half a line behind
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"path":"b.t`)
+	file.Close()
+
+	if err := openState(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := resumedCount(); got != 1 {
+		t.Errorf("resumedCount() = %d, want 1", got)
+	}
+	targetPath := filepath.Join(job.TargetDir, "a.txt")
+	if !job.copiedBefore("a.txt", info, targetPath) {
+		t.Error("copiedBefore() = false for a file the earlier run copied")
+	}
+	changed := fakeInfo{name: "a.txt", size: info.Size(), modTime: info.ModTime().Add(time.Hour)}
+	if job.copiedBefore("a.txt", changed, targetPath) {
+		t.Error("copiedBefore() = true for a file changed since")
+	}
+	if job.copiedBefore("a.txt", info, filepath.Join(job.TargetDir, "missing.txt")) {
+		t.Error("copiedBefore() = true for a target that is gone")
+	}
+
+	// A clean run leaves nothing to resume
+	closeState(true)
+	if fileExists(path) {
+		t.Error("closeState(true) kept the state file")
+	}
+}
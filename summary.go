@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// syncStats holds the run counters shared by all workers
+type syncStats struct {
+	scanned     atomic.Int64
+	copied      atomic.Int64
+	skipped     atomic.Int64
+	bytesCopied atomic.Int64
+	errors      atomic.Int64
+	// processed counts the files workers have finished with, for progress
+	processed atomic.Int64
+}
+
+// errTooManyErrors ends a run whose errors pass max_errors
+var errTooManyErrors = errors.New("too many errors")
+
+// countError adds a failure to the job's error count, aborting the run once
+// the total across jobs passes max_errors.
+func (j *jobRun) countError() {
+	j.stats.errors.Add(1)
+	if config.MaxErrors <= 0 || abortRun == nil {
+		return
+	}
+	total := int64(0)
+	for _, job := range jobs {
+		total += job.stats.errors.Load()
+	}
+	if total > int64(config.MaxErrors) {
+		abortRun(errTooManyErrors)
+	}
+}
+
+// runOutcome is the status of a run cut short, such as "timed_out", and is
+// empty for one that ran to the end
+var runOutcome string
+
+// runSummary is the machine-readable form of syncStats written at the end of a run
+type runSummary struct {
+	Name            string       `json:"name,omitempty"`
+	Status          string       `json:"status,omitempty"`
+	FilesScanned    int64        `json:"files_scanned"`
+	FilesCopied     int64        `json:"files_copied"`
+	FilesSkipped    int64        `json:"files_skipped"`
+	BytesCopied     int64        `json:"bytes_copied"`
+	Errors          int64        `json:"errors"`
+	DurationSeconds float64      `json:"duration_seconds,omitempty"`
+	LockedFiles     []string     `json:"locked_files,omitempty"`
+	FilesRemaining  int64        `json:"files_remaining,omitempty"`
+	Jobs            []runSummary `json:"jobs,omitempty"`
+}
+
+// summarize snapshots the current counters.
+func (s *syncStats) summarize() runSummary {
+	return runSummary{
+		FilesScanned: s.scanned.Load(),
+		FilesCopied:  s.copied.Load(),
+		FilesSkipped: s.skipped.Load(),
+		BytesCopied:  s.bytesCopied.Load(),
+		Errors:       s.errors.Load(),
+	}
+}
+
+// totalSummary adds up the counters of every job.
+func totalSummary(elapsed time.Duration) runSummary {
+	total := runSummary{DurationSeconds: elapsed.Seconds()}
+	for _, job := range jobs {
+		summary := job.stats.summarize()
+		total.FilesScanned += summary.FilesScanned
+		total.FilesCopied += summary.FilesCopied
+		total.FilesSkipped += summary.FilesSkipped
+		total.BytesCopied += summary.BytesCopied
+		total.Errors += summary.Errors
+		for _, relPath := range job.lockedFiles() {
+			total.LockedFiles = append(total.LockedFiles, job.prefix()+relPath)
+		}
+	}
+	return total
+}
+
+// formatBytes renders a byte count in the largest unit that keeps it above one,
+// e.g. 512 B, 3.4 MB or 1.2 GB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n) / unit
+	for _, suffix := range []string{"KB", "MB", "GB"} {
+		if value < unit || suffix == "GB" {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+		value /= unit
+	}
+	return ""
+}
+
+// formatThroughput describes a copy of n bytes that took elapsed, e.g.
+// "(12.3 MB in 4.1s, 3.0 MB/s)".
+func formatThroughput(n int64, elapsed time.Duration) string {
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		return fmt.Sprintf("(%s in 0.0s)", formatBytes(n))
+	}
+	return fmt.Sprintf("(%s in %.1fs, %s/s)", formatBytes(n), seconds, formatBytes(int64(float64(n)/seconds)))
+}
+
+// printSummaryBlock prints the end-of-run totals for copied, skipped and failed files.
+func printSummaryBlock(summary runSummary) {
+	printSummary("%s", colorize(ansiGreen, fmt.Sprintf("Copied:  %d files (%s)", summary.FilesCopied, formatBytes(summary.BytesCopied))))
+	printSummary("%s", colorize(ansiGray, fmt.Sprintf("Skipped: %d files", summary.FilesSkipped)))
+	if summary.Errors > 0 {
+		printSummary("%s", colorize(ansiRed, fmt.Sprintf("Errors:  %d", summary.Errors)))
+	} else {
+		printSummary("Errors:  0")
+	}
+}
+
+// writeSummaryJSON writes the run summary to path, or to stdout when path is "-".
+func writeSummaryJSON(path string, elapsed time.Duration) error {
+	out := os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(runReport(elapsed))
+}
+
+// runReport builds the full run summary shared by --summary-json and the
+// webhook, with a per-job breakdown when there is more than one job.
+func runReport(elapsed time.Duration) runSummary {
+	summary := totalSummary(elapsed)
+	summary.Status = "success"
+	if summary.Errors > 0 {
+		summary.Status = "errors"
+	}
+	// A run stopped by the soft deadline did not copy everything it found
+	summary.FilesRemaining = filesRemaining.Load()
+	if summary.FilesRemaining > 0 && summary.Errors == 0 {
+		summary.Status = "incomplete"
+	}
+	if runOutcome != "" {
+		summary.Status = runOutcome
+	}
+	if len(jobs) > 1 {
+		for _, job := range jobs {
+			jobSummary := job.stats.summarize()
+			jobSummary.Name = job.Name
+			jobSummary.LockedFiles = job.lockedFiles()
+			summary.Jobs = append(summary.Jobs, jobSummary)
+		}
+	} else if len(jobs) == 1 {
+		summary.Name = jobs[0].Name
+	}
+	return summary
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket holding up to one second's worth of bytes. A
+// single limiter is shared by every worker so the cap applies to the whole run.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// throttle limits aggregate copy throughput when max_bytes_per_sec is set
+var throttle *rateLimiter
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	return &rateLimiter{
+		rate:   float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// wait takes n bytes from the bucket, sleeping until the bucket could have
+// refilled enough to cover them.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit > 0 {
+		time.Sleep(time.Duration(deficit / l.rate * float64(time.Second)))
+	}
+}
+
+// throttledReader charges every read against a shared rateLimiter
+type throttledReader struct {
+	reader  io.Reader
+	limiter *rateLimiter
+}
+
+func (r throttledReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.limiter.wait(n)
+	}
+	return n, err
+}
+
+// openFileSlots caps how many copies hold their source and target open at
+// once when max_open_files is set, and is nil otherwise
+var openFileSlots chan struct{}
+
+// acquireOpenFile waits for a free slot in openFileSlots and returns the
+// function that releases it, or fails once ctx is cancelled.
+func acquireOpenFile(ctx context.Context) (func(), error) {
+	if openFileSlots == nil {
+		return func() {}, nil
+	}
+	select {
+	case openFileSlots <- struct{}{}:
+		return func() { <-openFileSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
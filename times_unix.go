@@ -0,0 +1,37 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// errBirthTimeUnsupported is returned by setBirthTime where the platform has
+// no way to set a file's creation time, such as Linux
+var errBirthTimeUnsupported = errors.New("setting the creation time is not supported on this platform")
+
+// birthTimeWarning makes sure the unsupported platform is only logged once
+var birthTimeWarning sync.Once
+
+// setFileTimes preserves the access and modification times of the source file,
+// and with preserve_birth_time its creation time where the platform allows.
+func setFileTimes(targetPath string, sourceInfo os.FileInfo) error {
+	err := os.Chtimes(targetPath, accessTime(sourceInfo), sourceInfo.ModTime())
+	if err != nil || !config.PreserveBirthTime {
+		return err
+	}
+
+	// The creation time is best effort, since few Unix systems can set it
+	err = setBirthTime(targetPath, sourceInfo)
+	if errors.Is(err, errBirthTimeUnsupported) {
+		birthTimeWarning.Do(func() {
+			logWarn("preserve_birth_time: creation times cannot be set on this platform, only access and modification times are kept")
+		})
+	} else if err != nil {
+		logDebug(fmt.Sprintf("Could not set creation time on %s: %v", targetPath, err))
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+func setFileTimes(targetPath string, sourceInfo os.FileInfo) error {
+	// Without the Windows file data, such as for a placeholder reported
+	// through a reparse point, only the mod time is known
+	stat, ok := sourceInfo.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return os.Chtimes(toLongPath(targetPath), sourceInfo.ModTime(), sourceInfo.ModTime())
+	}
+
+	// Convert times to windows.Filetime
+	creationTime := windows.NsecToFiletime(stat.CreationTime.Nanoseconds())
+	lastAccessTime := windows.NsecToFiletime(stat.LastAccessTime.Nanoseconds())
+	lastWriteTime := windows.NsecToFiletime(stat.LastWriteTime.Nanoseconds())
+
+	handle, err := windows.CreateFile(
+		windows.StringToUTF16Ptr(toLongPath(targetPath)),
+		windows.FILE_WRITE_ATTRIBUTES,
+		windows.FILE_SHARE_WRITE,
+		nil,
+		windows.OPEN_EXISTING,
+		// Backup semantics lets the same call open directories
+		windows.FILE_ATTRIBUTE_NORMAL|windows.FILE_FLAG_BACKUP_SEMANTICS,
+		0,
+	)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(handle)
+
+	// Set the file times
+	err = windows.SetFileTime(handle, &creationTime, &lastAccessTime, &lastWriteTime)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
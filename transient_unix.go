@@ -0,0 +1,51 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// transientErrors are failures that typically clear up on their own, such as a
+// busy file or a timeout on a network mount.
+var transientErrors = []error{
+	syscall.EAGAIN,
+	syscall.EBUSY,
+	syscall.EINTR,
+	syscall.ETIMEDOUT,
+}
+
+// volumeGoneErrors mean the device or mount behind a path has gone away
+var volumeGoneErrors = []error{
+	syscall.EIO,
+	syscall.ENODEV,
+	syscall.ENXIO,
+	syscall.ENOTCONN,
+}
+
+// isLockedError reports whether err means the file is busy in another process.
+func isLockedError(err error) bool {
+	return errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.ETXTBSY)
+}
+
+// isTransientError reports whether err is worth retrying.
+func isTransientError(err error) bool {
+	for _, transient := range transientErrors {
+		if errors.Is(err, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// isVolumeGoneError reports whether err means the volume holding the file has
+// disconnected.
+func isVolumeGoneError(err error) bool {
+	for _, gone := range volumeGoneErrors {
+		if errors.Is(err, gone) {
+			return true
+		}
+	}
+	return false
+}
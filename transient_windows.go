@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// transientErrors are failures that typically clear up on their own, such as a
+// file briefly held open by another process or a hiccup on a network share.
+var transientErrors = []error{
+	windows.ERROR_SHARING_VIOLATION,
+	windows.ERROR_LOCK_VIOLATION,
+	windows.ERROR_NETWORK_BUSY,
+	windows.ERROR_UNEXP_NET_ERR,
+	windows.ERROR_NETNAME_DELETED,
+	windows.ERROR_SEM_TIMEOUT,
+}
+
+// volumeGoneErrors mean the drive or share behind a path has gone away, such
+// as an unplugged USB disk
+var volumeGoneErrors = []error{
+	windows.ERROR_NOT_READY,
+	windows.ERROR_DEVICE_NOT_CONNECTED,
+	windows.ERROR_DEV_NOT_EXIST,
+	windows.ERROR_BAD_NETPATH,
+}
+
+// isLockedError reports whether err means another process has the file open
+// exclusively, such as a mailbox or database in use.
+func isLockedError(err error) bool {
+	return errors.Is(err, windows.ERROR_SHARING_VIOLATION) || errors.Is(err, windows.ERROR_LOCK_VIOLATION)
+}
+
+// isTransientError reports whether err is worth retrying.
+func isTransientError(err error) bool {
+	for _, transient := range transientErrors {
+		if errors.Is(err, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// isVolumeGoneError reports whether err means the volume holding the file has
+// disconnected.
+func isVolumeGoneError(err error) bool {
+	for _, gone := range volumeGoneErrors {
+		if errors.Is(err, gone) {
+			return true
+		}
+	}
+	return false
+}
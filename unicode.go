@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Unicode normalization forms for normalize_unicode
+const (
+	normalizeNFC = "nfc"
+	normalizeNFD = "nfd"
+)
+
+// normalizePath puts relPath in the Unicode form set by normalize_unicode, so
+// names that differ only in how accents are encoded land on one target path.
+func normalizePath(relPath string) string {
+	switch config.NormalizeUnicode {
+	case normalizeNFC:
+		return norm.NFC.String(relPath)
+	case normalizeNFD:
+		return norm.NFD.String(relPath)
+	}
+	return relPath
+}
+
+// findSource returns the source path for relPath, which is in the form used
+// in the target, and whether it exists. With normalize_unicode the source may
+// spell a name in another form, so each element is matched by its normalized
+// name when the path does not exist as it is.
+func (j *jobRun) findSource(relPath string) (string, bool) {
+	path := filepath.Join(j.SourceDir, relPath)
+	if fileExists(path) {
+		return path, true
+	}
+	if config.NormalizeUnicode == "" {
+		return path, false
+	}
+
+	dir := j.SourceDir
+	for _, name := range strings.Split(relPath, string(filepath.Separator)) {
+		if fileExists(filepath.Join(dir, name)) {
+			dir = filepath.Join(dir, name)
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return path, false
+		}
+		found := false
+		for _, entry := range entries {
+			if normalizePath(entry.Name()) == name {
+				dir = filepath.Join(dir, entry.Name())
+				found = true
+				break
+			}
+		}
+		if !found {
+			return path, false
+		}
+	}
+	return dir, true
+}
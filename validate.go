@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// validateConfig checks every job before anything is written, so typos and
+// dangerous layouts fail loudly instead of producing an empty or runaway sync.
+func validateConfig(config Config, dryRun bool) error {
+	err := validateSettings(config)
+	if err != nil {
+		return err
+	}
+	return validateJobs(config, dryRun)
+}
+
+// validateSettings checks the options of the config that need no look at
+// the source or target directories.
+func validateSettings(config Config) error {
+	switch config.Conflict {
+	case "", conflictNewest, conflictSource, conflictTarget, conflictSkip:
+	default:
+		return fmt.Errorf("unknown conflict policy %q, expected newest, source, target or skip", config.Conflict)
+	}
+	for _, strategy := range config.Compare.strategies() {
+		switch strategy {
+		case "", compareModTime, compareSize, compareChecksum:
+		default:
+			return fmt.Errorf("unknown compare strategy %q, expected modtime, size or checksum", strategy)
+		}
+	}
+	switch config.Dehydrated {
+	case "", dehydratedSkip, dehydratedHydrate:
+	default:
+		return fmt.Errorf("unknown dehydrated policy %q, expected skip or hydrate", config.Dehydrated)
+	}
+	switch config.NormalizeUnicode {
+	case "", normalizeNFC, normalizeNFD:
+	default:
+		return fmt.Errorf("unknown normalize_unicode form %q, expected nfc or nfd", config.NormalizeUnicode)
+	}
+	switch config.CaseCollision {
+	case "", collisionSkip, collisionRename, collisionOff:
+	default:
+		return fmt.Errorf("unknown case_collision policy %q, expected skip, rename or off", config.CaseCollision)
+	}
+	if config.MaxDepth != nil && *config.MaxDepth < 0 {
+		return fmt.Errorf("max_depth %d cannot be negative", *config.MaxDepth)
+	}
+	if !config.ModifiedAfter.IsZero() && !config.ModifiedBefore.IsZero() && !config.ModifiedAfter.Before(config.ModifiedBefore) {
+		return fmt.Errorf("modified_after %s is not before modified_before %s", config.ModifiedAfter.Format(time.RFC3339), config.ModifiedBefore.Format(time.RFC3339))
+	}
+	if config.Dedupe && config.Compress {
+		return errors.New("dedupe cannot be combined with compress")
+	}
+	if config.ForceAll && config.NoOverwrite {
+		return errors.New("force_all cannot be combined with no_overwrite")
+	}
+	if config.CompensateClockSkew && !config.CheckClockSkew {
+		return errors.New("compensate_clock_skew needs check_clock_skew")
+	}
+	if config.FileTimeoutSeconds < 0 {
+		return fmt.Errorf("file_timeout_seconds cannot be negative")
+	}
+	if config.MaxErrors < 0 {
+		return fmt.Errorf("max_errors %d cannot be negative", config.MaxErrors)
+	}
+	if config.NewestPerDir < 0 {
+		return fmt.Errorf("newest_per_dir %d cannot be negative", config.NewestPerDir)
+	}
+	if config.MaxOpenFiles < 0 {
+		return fmt.Errorf("max_open_files %d cannot be negative", config.MaxOpenFiles)
+	}
+	if config.MaxSizeBytes > 0 && config.MaxSizeBytes < config.MinSizeBytes {
+		return fmt.Errorf("max_size_bytes %d is smaller than min_size_bytes %d", config.MaxSizeBytes, config.MinSizeBytes)
+	}
+	if config.ArchiveBitMode && runtime.GOOS != "windows" {
+		return errors.New("archive_bit_mode is only supported on Windows")
+	}
+	for _, name := range config.ExcludeAttributes {
+		switch name {
+		case attributeHidden, attributeSystem, attributeTemporary, attributeReadOnly:
+		default:
+			return fmt.Errorf("unknown exclude_attributes value %q, expected hidden, system, temporary or readonly", name)
+		}
+	}
+	if len(config.ExcludeAttributes) > 0 && runtime.GOOS != "windows" {
+		return errors.New("exclude_attributes is only supported on Windows")
+	}
+	if config.RecycleOnDelete && runtime.GOOS != "windows" {
+		return errors.New("recycle_on_delete is only supported on Windows")
+	}
+	if config.Compress && (config.Conflict == conflictNewest || config.Conflict == conflictTarget) {
+		return fmt.Errorf("conflict policy %q copies back to the source and cannot be combined with compress", config.Conflict)
+	}
+
+	if prefix := filepath.Clean(config.TargetPrefix); config.TargetPrefix != "" &&
+		(filepath.IsAbs(prefix) || prefix == ".." || strings.HasPrefix(prefix, ".."+string(filepath.Separator))) {
+		return fmt.Errorf("target_prefix %s must be a folder inside the target", config.TargetPrefix)
+	}
+	if len(config.TargetDirs) > 0 && (config.TargetDir != "" || len(config.Jobs) > 0) {
+		return errors.New("target_dirs cannot be combined with target_dir or jobs")
+	}
+	return nil
+}
+
+// validateJobs checks the source and target directories of every job.
+func validateJobs(config Config, dryRun bool) error {
+	for i, job := range config.jobList() {
+		name := job.Name
+		if name == "" {
+			name = fmt.Sprintf("job %d", i+1)
+		}
+
+		err := validateJob(job, config.AllowNested, dryRun)
+		if err != nil {
+			if len(config.Jobs) == 0 {
+				return err
+			}
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// validateJob checks a single source/target pair. Nested source and target
+// directories are refused unless allowNested is set, and the target is only
+// probed for write access when dryRun is false.
+func validateJob(job Job, allowNested, dryRun bool) error {
+	if job.SourceDir == "" || job.TargetDir == "" {
+		return errors.New("source and target directories must be specified in the configuration file")
+	}
+
+	info, err := os.Stat(job.SourceDir)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("source directory %s does not exist", job.SourceDir)
+	} else if err != nil {
+		return fmt.Errorf("cannot read source directory %s: %w", job.SourceDir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("source %s is not a directory", job.SourceDir)
+	}
+
+	sourcePath, err := resolvePath(job.SourceDir)
+	if err != nil {
+		return err
+	}
+	targetPath, err := resolvePath(job.TargetDir)
+	if err != nil {
+		return err
+	}
+	if !allowNested {
+		if isWithin(sourcePath, targetPath) {
+			return fmt.Errorf("target %s is inside source %s, which would copy the target into itself (set allow_nested to override)", job.TargetDir, job.SourceDir)
+		}
+		if isWithin(targetPath, sourcePath) {
+			return fmt.Errorf("source %s is inside target %s (set allow_nested to override)", job.SourceDir, job.TargetDir)
+		}
+	}
+
+	// The target may not exist yet, so probe the closest directory that does.
+	// A dry run promises to leave the target alone, so it skips the probe.
+	if !dryRun {
+		err = checkWritable(existingAncestor(targetPath))
+		if err != nil {
+			return fmt.Errorf("target %s is not writable: %w", job.TargetDir, err)
+		}
+	}
+
+	return nil
+}
+
+// resolvePath makes path absolute and resolves symlinks in the part of it that
+// already exists, so two spellings of the same directory compare equal.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	existing := existingAncestor(abs)
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return abs, nil
+	}
+	rest, err := filepath.Rel(existing, abs)
+	if err != nil {
+		return abs, nil
+	}
+	return filepath.Join(resolved, rest), nil
+}
+
+// nestedRel returns the path of inner relative to outer when inner lies
+// strictly inside outer.
+func nestedRel(outer, inner string) (string, bool) {
+	if !isWithin(outer, inner) {
+		return "", false
+	}
+	rel, err := filepath.Rel(outer, inner)
+	if err != nil || rel == "." {
+		return "", false
+	}
+	return rel, true
+}
+
+// isWithin reports whether path is parent itself or lies underneath it.
+func isWithin(parent, path string) bool {
+	if runtime.GOOS == "windows" {
+		parent, path = strings.ToLower(parent), strings.ToLower(path)
+	}
+	rel, err := filepath.Rel(parent, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// checkWritable creates and removes a probe file in dir.
+func checkWritable(dir string) error {
+	probe, err := os.CreateTemp(dir, ".gosync-probe-*")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateJobNesting(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name        string
+		job         Job
+		allowNested bool
+		wantErr     bool
+	}{
+		{name: "side by side", job: Job{SourceDir: dir, TargetDir: filepath.Join(t.TempDir(), "target")}},
+		{name: "target in source", job: Job{SourceDir: dir, TargetDir: filepath.Join(dir, "backup")}, wantErr: true},
+		{name: "source in target", job: Job{SourceDir: dir, TargetDir: filepath.Dir(dir)}, wantErr: true},
+		{name: "same directory", job: Job{SourceDir: dir, TargetDir: dir}, wantErr: true},
+		{name: "allowed", job: Job{SourceDir: dir, TargetDir: filepath.Join(dir, "backup")}, allowNested: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateJob(test.job, test.allowNested, false)
+			if (err != nil) != test.wantErr {
+				t.Errorf("validateJob() = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is how long a file must stay quiet before a change is synced,
+// so a burst of writes to the same file results in a single copy
+const watchDebounce = time.Second
+
+// sourceWatcher re-syncs files as they are created or modified under the job sources
+type sourceWatcher struct {
+	watcher *fsnotify.Watcher
+	queue   *taskQueue
+
+	// running counts the debounced changes being queued, which stopPending
+	// waits for so none is pushed after the queue is closed
+	mu      sync.Mutex
+	pending map[string]*time.Timer
+	stopped bool
+	running sync.WaitGroup
+}
+
+// watchJobs keeps every job's target up to date with changes under its source
+// until ctx is cancelled, handing each changed file to the workers on queue.
+func watchJobs(ctx context.Context, queue *taskQueue) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	w := &sourceWatcher{watcher: watcher, queue: queue, pending: make(map[string]*time.Timer)}
+	defer w.stopPending()
+	for _, job := range jobs {
+		// Further target_dirs get their changes from the first target's watch
+		if job.leader == nil {
+			w.addTree(ctx, job, toLongPath(job.SourceDir))
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Create) || event.Has(fsnotify.Write) {
+				w.schedule(ctx, event.Name)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logError(fmt.Sprintf("Error watching for changes: %v", err))
+		}
+	}
+}
+
+// addTree watches dir and every directory below it that the walk would enter.
+func (w *sourceWatcher) addTree(ctx context.Context, job *jobRun, dir string) {
+	err := w.watcher.Add(dir)
+	if err != nil {
+		job.logError(fmt.Sprintf("Error watching %s: %v", dir, err))
+		return
+	}
+
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return filepath.SkipAll
+		}
+		if err != nil || !info.IsDir() || path == dir {
+			return nil
+		}
+		relPath, err := filepath.Rel(toLongPath(job.SourceDir), path)
+		if err != nil || job.dirSkipReason(relPath) != "" {
+			return filepath.SkipDir
+		}
+		err = w.watcher.Add(path)
+		if err != nil {
+			job.logError(fmt.Sprintf("Error watching %s: %v", path, err))
+		}
+		return nil
+	})
+}
+
+// schedule syncs path once it has been quiet for watchDebounce, restarting
+// the wait if another event for it arrives first.
+func (w *sourceWatcher) schedule(ctx context.Context, path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stopped {
+		return
+	}
+	if timer, ok := w.pending[path]; ok {
+		timer.Reset(watchDebounce)
+		return
+	}
+	w.pending[path] = time.AfterFunc(watchDebounce, func() {
+		w.mu.Lock()
+		delete(w.pending, path)
+		if w.stopped {
+			w.mu.Unlock()
+			return
+		}
+		w.running.Add(1)
+		w.mu.Unlock()
+		defer w.running.Done()
+
+		if ctx.Err() == nil {
+			w.syncChanged(ctx, path)
+		}
+	})
+}
+
+// stopPending cancels every change still waiting out its debounce, and waits
+// for those already being queued.
+func (w *sourceWatcher) stopPending() {
+	w.mu.Lock()
+	w.stopped = true
+	for path, timer := range w.pending {
+		timer.Stop()
+		delete(w.pending, path)
+	}
+	w.mu.Unlock()
+	w.running.Wait()
+}
+
+// syncChanged queues a changed file for the workers, or starts watching a
+// newly created directory and syncs what is already in it.
+func (w *sourceWatcher) syncChanged(ctx context.Context, path string) {
+	job, relPath := jobForPath(path)
+	if job == nil || job.parentSkipReason(relPath) != "" {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		// Removed again before the debounce expired
+		return
+	}
+
+	if info.IsDir() {
+		if job.dirSkipReason(relPath) != "" {
+			return
+		}
+		w.addTree(ctx, job, path)
+		filepath.Walk(path, func(subPath string, subInfo os.FileInfo, err error) error {
+			if err == nil && !subInfo.IsDir() {
+				w.schedule(ctx, subPath)
+			}
+			return nil
+		})
+		return
+	}
+
+	if reason := job.fileSkipReason(relPath, info); reason != "" {
+		job.logDebug(fmt.Sprintf("Skipped by %s: %s", reason, relPath))
+		return
+	}
+	for _, target := range job.group() {
+		target.stats.scanned.Add(1)
+	}
+	w.queue.push(syncTask{job: job, path: path, relPath: relPath, info: info})
+}
+
+// jobForPath finds the job whose source contains path and returns path relative to it.
+func jobForPath(path string) (*jobRun, string) {
+	for _, job := range jobs {
+		relPath, err := filepath.Rel(toLongPath(job.SourceDir), path)
+		if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return job, relPath
+	}
+	return nil, ""
+}
+
+// parentSkipReason returns the dirSkipReason of a directory above relPath
+// that the walk would not enter, or "" when there is none.
+func (j *jobRun) parentSkipReason(relPath string) string {
+	for dir := filepath.Dir(relPath); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if reason := j.dirSkipReason(dir); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// webhookTimeout keeps an unreachable monitoring endpoint from holding up exit
+const webhookTimeout = 10 * time.Second
+
+// postWebhook sends the run summary as JSON to url.
+func postWebhook(url string, elapsed time.Duration) error {
+	body, err := json.Marshal(runReport(elapsed))
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: webhookTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+	return nil
+}